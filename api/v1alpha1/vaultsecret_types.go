@@ -0,0 +1,176 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// VaultSecretSpec defines the desired state of VaultSecret
+type VaultSecretSpec struct {
+	// Backend is the name of the backend to read from.
+	// This is the equivalent of the vault.mmlt.nl/inject-backend annotation.
+	// Defaults to the default backend.
+	// +optional
+	Backend string `json:"backend,omitempty"`
+
+	// Sources are the Vault paths to read.
+	// +kubebuilder:validation:MinItems=1
+	Sources []VaultSecretSource `json:"sources"`
+
+	// Templates are Go text/templates that produce target Secret data.
+	// The map key is the Secret data key, the value is the template.
+	// Templates are executed with the values of all sources, for example: {{ .db.user }}:{{ .db.password }}
+	// +optional
+	Templates map[string]string `json:"templates,omitempty"`
+
+	// Target is the Secret that is generated.
+	// +optional
+	Target VaultSecretTarget `json:"target,omitempty"`
+
+	// RefreshInterval is the time between reads from Vault.
+	// When not set the Secret is only updated when the VaultSecret changes.
+	// +optional
+	RefreshInterval *metav1.Duration `json:"refreshInterval,omitempty"`
+}
+
+// VaultSecretSource is a Vault path to read.
+type VaultSecretSource struct {
+	// Name of the source, used to refer to its values in templates.
+	// Defaults to "source<index>", for example "source0".
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Path in Vault where the secret is located relative to vault-secret-path.
+	// This is the equivalent of the vault.mmlt.nl/inject-path annotation.
+	Path string `json:"path"`
+
+	// Fields maps Secret data keys to Vault field names.
+	// This is the equivalent of the vault.mmlt.nl/inject-fields annotation.
+	// +optional
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// VaultSecretTarget describes the Secret that is generated.
+type VaultSecretTarget struct {
+	// Name of the Secret, defaults to the name of the VaultSecret.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Type of the Secret, defaults to Opaque.
+	// +optional
+	Type corev1.SecretType `json:"type,omitempty"`
+
+	// Labels to set on the Secret.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Annotations to set on the Secret.
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// VaultSecretStatus defines the observed state of VaultSecret
+type VaultSecretStatus struct {
+	// ObservedGeneration is the VaultSecret generation that has been synced.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// LastSyncTime is the time the Secret was last successfully synced with Vault.
+	// +optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+
+	// Conditions of the VaultSecret.
+	// +optional
+	Conditions []VaultSecretCondition `json:"conditions,omitempty"`
+}
+
+// VaultSecretConditionType is the type of a VaultSecretCondition.
+type VaultSecretConditionType string
+
+const (
+	// VaultSecretReady is True when the target Secret is in sync with Vault.
+	VaultSecretReady VaultSecretConditionType = "Ready"
+)
+
+// VaultSecretCondition describes the state of a VaultSecret at a certain point.
+type VaultSecretCondition struct {
+	// Type of condition.
+	Type VaultSecretConditionType `json:"type"`
+	// Status of the condition, one of True, False, Unknown.
+	Status corev1.ConditionStatus `json:"status"`
+	// LastTransitionTime is the last time the condition transitioned from one status to another.
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// Reason is a one-word CamelCase reason for the condition's last transition.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// Message is a human-readable message indicating details about last transition.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Last Sync",type=date,JSONPath=`.status.lastSyncTime`
+
+// VaultSecret is the Schema for the vaultsecrets API
+type VaultSecret struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   VaultSecretSpec   `json:"spec,omitempty"`
+	Status VaultSecretStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// VaultSecretList contains a list of VaultSecret
+type VaultSecretList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []VaultSecret `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&VaultSecret{}, &VaultSecretList{})
+}
+
+// TargetName returns the name of the Secret that is generated.
+func (in *VaultSecret) TargetName() string {
+	if in.Spec.Target.Name != "" {
+		return in.Spec.Target.Name
+	}
+	return in.Name
+}
+
+// SetCondition sets a condition, the transition time is only updated when the status changes.
+func (in *VaultSecretStatus) SetCondition(c VaultSecretCondition) {
+	for i := range in.Conditions {
+		if in.Conditions[i].Type != c.Type {
+			continue
+		}
+		if in.Conditions[i].Status == c.Status {
+			c.LastTransitionTime = in.Conditions[i].LastTransitionTime
+		}
+		in.Conditions[i] = c
+		return
+	}
+	in.Conditions = append(in.Conditions, c)
+}
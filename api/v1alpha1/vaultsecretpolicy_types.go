@@ -0,0 +1,70 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// VaultSecretPolicySpec defines the Vault paths, roles and backends that namespaces are allowed to use.
+type VaultSecretPolicySpec struct {
+	// NamespaceSelector selects the namespaces the policy applies to.
+	// An empty selector selects all namespaces.
+	// +optional
+	NamespaceSelector metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// AllowedPaths are globs of the Vault paths (after applying vault-secret-path) that can be read or written.
+	// {ns} is replaced by the namespace, * matches a path element and a trailing /** matches any number of elements.
+	// For example "secret/data/ns/{ns}/**". When empty all paths are allowed.
+	// +optional
+	AllowedPaths []string `json:"allowedPaths,omitempty"`
+
+	// AllowedRoles are globs of the Vault roles (after applying vault-role) that can be used.
+	// {ns} is replaced by the namespace. When empty all roles are allowed.
+	// +optional
+	AllowedRoles []string `json:"allowedRoles,omitempty"`
+
+	// AllowedBackends are the names of the backends that can be used, "" is the default backend.
+	// When empty all backends are allowed.
+	// +optional
+	AllowedBackends []string `json:"allowedBackends,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+
+// VaultSecretPolicy restricts the use of Vault by namespaces.
+// When policies are enabled a request must be allowed by at least one of the policies that select its namespace.
+type VaultSecretPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec VaultSecretPolicySpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// VaultSecretPolicyList contains a list of VaultSecretPolicy
+type VaultSecretPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []VaultSecretPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&VaultSecretPolicy{}, &VaultSecretPolicyList{})
+}
@@ -6,7 +6,6 @@ import (
 	"github.com/stretchr/testify/assert"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"testing"
-	"time"
 )
 
 func TestFakevault(t *testing.T) {
@@ -14,7 +13,7 @@ func TestFakevault(t *testing.T) {
 
 	logf.SetLogger(testr.New(t))
 
-	testManager(t, fakeVault(map[string]string{
+	done := testManager(t, fakeVault(map[string]string{
 		"one": "first-value",
 		"two": "second-value",
 	}), stop)
@@ -103,14 +102,25 @@ func TestFakevault(t *testing.T) {
 		assert.Equal(t, 1, len(got.Data))
 	})
 
+	t.Run("should_reject_Secret_with_unknown_backend", func(t *testing.T) {
+		testDeleteSecret(t)
+		err := testCreateSecretErr(map[string]string{
+			"vault.mmlt.nl/inject":         "true",
+			"vault.mmlt.nl/inject-path":    "path/to/secret",
+			"vault.mmlt.nl/inject-fields":  "een=one,twee=two",
+			"vault.mmlt.nl/inject-backend": "unknown",
+		}, nil)
+		assert.Error(t, err)
+	})
+
 	// teardown manager
 	close(stop)
-	time.Sleep(time.Second) //TODO how to wait for manager shutdown?
+	<-done
 }
 
 type fakeVault map[string]string
 
-func (v fakeVault) Login(_, _ string) (vault.Getter, error) {
+func (v fakeVault) Login(_ vault.LoginRequest) (vault.Getter, error) {
 	return v, nil
 }
 
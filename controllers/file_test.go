@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"github.com/mmlt/testr"
+	"github.com/mmlt/vault-secret/pkg/vault/file"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"testing"
+)
+
+// TestFileBackend runs test cases against a file backend.
+// It's a lightweight alternative to TestVault for testing annotation behaviour end-to-end.
+func TestFileBackend(t *testing.T) {
+	logf.SetLogger(testr.New(t))
+
+	dir, err := ioutil.TempDir("", "vaultsecret")
+	mustNotErr("creating temp dir", err)
+	defer os.RemoveAll(dir)
+	err = os.MkdirAll(filepath.Join(dir, "path", "to", "keys"), 0700)
+	mustNotErr("creating dir", err)
+	err = ioutil.WriteFile(filepath.Join(dir, "path", "to", "test.yaml"), []byte("one: first-file-value\ntwo: second-file-value\n"), 0600)
+	mustNotErr("writing file", err)
+	err = ioutil.WriteFile(filepath.Join(dir, "path", "to", "keys", "one"), []byte("first-key-value"), 0600)
+	mustNotErr("writing file", err)
+
+	b, err := file.NewWatched(dir)
+	mustNotErr("creating file backend", err)
+	defer b.Close()
+
+	// Instantiate (webhook) manager.
+	stop := make(chan struct{})
+	done := testManager(t, b, stop)
+
+	t.Run("should_get_data_fields_from_file", func(t *testing.T) {
+		testCreateSecret(t, map[string]string{
+			"vault.mmlt.nl/inject":        "true",
+			"vault.mmlt.nl/inject-path":   "path/to/test",
+			"vault.mmlt.nl/inject-fields": "een=one,twee=two",
+		}, nil)
+		got := testGetSecret(t)
+		assert.Equal(t, map[string]string{
+			"een":  "first-file-value",
+			"twee": "second-file-value",
+		}, msb2mss(got.Data))
+	})
+
+	t.Run("should_get_data_fields_from_file_per_key", func(t *testing.T) {
+		testCreateSecret(t, map[string]string{
+			"vault.mmlt.nl/inject":        "true",
+			"vault.mmlt.nl/inject-path":   "path/to/keys",
+			"vault.mmlt.nl/inject-fields": "een=one",
+		}, nil)
+		got := testGetSecret(t)
+		assert.Equal(t, map[string]string{
+			"een": "first-key-value",
+		}, msb2mss(got.Data))
+	})
+
+	t.Run("should_reject_Secret_when_path_is_missing", func(t *testing.T) {
+		testDeleteSecret(t)
+		err := testCreateSecretErr(map[string]string{
+			"vault.mmlt.nl/inject":        "true",
+			"vault.mmlt.nl/inject-path":   "path/to/missing",
+			"vault.mmlt.nl/inject-fields": "een=one",
+		}, nil)
+		assert.Error(t, err)
+	})
+
+	// teardown manager
+	close(stop)
+	<-done
+}
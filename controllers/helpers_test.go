@@ -1,31 +1,44 @@
 package controllers
 
 import (
+	vaultv1alpha1 "github.com/mmlt/vault-secret/api/v1alpha1"
 	"github.com/stretchr/testify/assert"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
 	"testing"
+	"time"
 )
 
 func testCreateSecret(t *testing.T, annotations map[string]string, data map[string][]byte) {
 	t.Helper()
 
 	testDeleteSecret(t)
+	err := testCreateSecretErr(annotations, data)
+	assert.NoError(t, err)
+}
+
+// TestCreateSecretErr creates the test Secret and returns the error (if any).
+func testCreateSecretErr(annotations map[string]string, data map[string][]byte) error {
 	secret := &corev1.Secret{}
 	secret.Namespace = testNSN.Namespace
 	secret.Name = testNSN.Name
 	secret.Data = data
 	secret.Annotations = annotations
-	err := k8sClient.Create(testCtx, secret)
-	assert.NoError(t, err)
+	return k8sClient.Create(testCtx, secret)
 }
 
 func testDeleteSecret(t *testing.T) {
 	t.Helper()
 
+	testDeleteSecretNSN(t, testNSN)
+}
+
+func testDeleteSecretNSN(t *testing.T, nsn types.NamespacedName) {
+	t.Helper()
+
 	obj := &corev1.Secret{}
-	err := k8sClient.Get(testCtx, testNSN, obj)
+	err := k8sClient.Get(testCtx, nsn, obj)
 	if apierrors.IsNotFound(err) {
 		return
 	}
@@ -104,3 +117,45 @@ func mustNotErr(msg string, err error) {
 		panic(msg + ": " + err.Error())
 	}
 }
+
+func testCreateVaultSecret(t *testing.T, spec vaultv1alpha1.VaultSecretSpec) {
+	t.Helper()
+
+	testDeleteVaultSecret(t)
+	vs := &vaultv1alpha1.VaultSecret{}
+	vs.Namespace = testNSN.Namespace
+	vs.Name = testNSN.Name
+	vs.Spec = spec
+	err := k8sClient.Create(testCtx, vs)
+	assert.NoError(t, err)
+}
+
+func testDeleteVaultSecret(t *testing.T) {
+	t.Helper()
+
+	obj := &vaultv1alpha1.VaultSecret{}
+	err := k8sClient.Get(testCtx, testNSN, obj)
+	if apierrors.IsNotFound(err) {
+		return
+	}
+	assert.NoError(t, err)
+	err = k8sClient.Delete(testCtx, obj)
+	assert.NoError(t, err)
+}
+
+// TestEventuallyGetSecret waits for Secret nsn to exist and returns it.
+func testEventuallyGetSecret(t *testing.T, nsn types.NamespacedName) *corev1.Secret {
+	t.Helper()
+
+	secret := &corev1.Secret{}
+	var err error
+	for i := 0; i < 50; i++ {
+		err = k8sClient.Get(testCtx, nsn, secret)
+		if err == nil {
+			return secret
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.NoError(t, err)
+	return secret
+}
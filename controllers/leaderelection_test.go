@@ -0,0 +1,180 @@
+package controllers
+
+import (
+	"crypto/tls"
+	"github.com/mmlt/testr"
+	"github.com/mmlt/vault-secret/pkg/mutator"
+	"github.com/mmlt/vault-secret/pkg/shutdown"
+	"github.com/mmlt/vault-secret/pkg/vault"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"net"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestLeaderElection runs two managers (replicas) and checks that both serve the webhook while only the leader
+// reconciles.
+func TestLeaderElection(t *testing.T) {
+	logf.SetLogger(testr.New(t))
+
+	const name = "test-leader"
+	stops := []chan struct{}{make(chan struct{}), make(chan struct{})}
+	reconciles := make([]int32, len(stops))
+	ports := make([]int, len(stops))
+	dones := make([]<-chan struct{}, len(stops))
+	for i := range stops {
+		ports[i] = testFreePort(t)
+		dones[i] = testElectedManager(t, ports[i], name, &reconciles[i], stops[i])
+	}
+
+	t.Run("should_serve_webhook_on_all_replicas", func(t *testing.T) {
+		for _, port := range ports {
+			addr := net.JoinHostPort(testEnv.WebhookInstallOptions.LocalServingHost, strconv.Itoa(port))
+			var err error
+			for i := 0; i < 50; i++ {
+				var c *tls.Conn
+				c, err = tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+				if err == nil {
+					c.Close()
+					break
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+			assert.NoError(t, err, addr)
+		}
+	})
+
+	cm := &corev1.ConfigMap{}
+	cm.Namespace, cm.Name = testNSN.Namespace, name
+
+	leader := -1
+	t.Run("should_reconcile_on_leader_only", func(t *testing.T) {
+		assert.NoError(t, k8sClient.Create(testCtx, cm))
+		leader = testEventuallyReconciled(reconciles)
+		if !assert.NotEqual(t, -1, leader, "no replica reconciled") {
+			return
+		}
+		time.Sleep(time.Second)
+		assert.EqualValues(t, 0, atomic.LoadInt32(&reconciles[1-leader]), "non-leader reconciled")
+	})
+
+	t.Run("should_fail_over_when_leader_stops", func(t *testing.T) {
+		if leader == -1 {
+			t.Skip("no leader")
+		}
+		close(stops[leader])
+		stops[leader] = nil
+
+		cm.Data = map[string]string{"changed": "true"}
+		assert.NoError(t, k8sClient.Update(testCtx, cm))
+		var n int32
+		for i := 0; i < 100 && n == 0; i++ {
+			time.Sleep(100 * time.Millisecond)
+			n = atomic.LoadInt32(&reconciles[1-leader])
+		}
+		assert.NotZero(t, n, "other replica didn't take over")
+	})
+
+	_ = k8sClient.Delete(testCtx, cm)
+	for i, stop := range stops {
+		if stop != nil {
+			close(stop)
+		}
+		<-dones[i]
+	}
+}
+
+// TestElectedManager starts a manager with leader election that serves the webhook on port and counts the reconciles
+// of the ConfigMap with name.
+// The returned channel is closed when the manager has stopped.
+func testElectedManager(t *testing.T, port int, name string, reconciles *int32, stop <-chan struct{}) <-chan struct{} {
+	t.Helper()
+
+	done := make(chan struct{})
+
+	leaseDuration, renewDeadline, retryPeriod := 2*time.Second, time.Second, 200*time.Millisecond
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+		MetricsBindAddress:      "0",
+		Host:                    testEnv.WebhookInstallOptions.LocalServingHost,
+		Port:                    port,
+		CertDir:                 testEnv.WebhookInstallOptions.LocalServingCertDir,
+		LeaderElection:          true,
+		LeaderElectionID:        "vaultsecret-test-leader",
+		LeaderElectionNamespace: testNSN.Namespace,
+		LeaseDuration:           &leaseDuration,
+		RenewDeadline:           &renewDeadline,
+		RetryPeriod:             &retryPeriod,
+	})
+	if !assert.NoError(t, err) {
+		close(done)
+		return done
+	}
+
+	drainer := &shutdown.Drainer{}
+	mgr.GetWebhookServer().Register(WebhookPath, &webhook.Admission{
+		Handler: drainer.Handler(&mutator.SecretMutator{
+			Backends: vault.NewBackends(fakeVault(nil)),
+			Settings: mutator.NewSettings(mutator.Templates{
+				VaultAuthPath:   "kubernetes",
+				VaultRole:       "vaultsecret-{ns}",
+				VaultSecretPath: "{p}",
+			}),
+			Log: logf.Log,
+		}),
+	})
+
+	err = ctrl.NewControllerManagedBy(mgr).
+		Named("count-" + strconv.Itoa(port)).
+		For(&corev1.ConfigMap{}).
+		Complete(reconcile.Func(func(req reconcile.Request) (reconcile.Result, error) {
+			if req.Name == name {
+				atomic.AddInt32(reconciles, 1)
+			}
+			return reconcile.Result{}, nil
+		}))
+	if !assert.NoError(t, err) {
+		close(done)
+		return done
+	}
+
+	go func() {
+		defer close(done)
+		err := mgr.Start(stop)
+		assert.NoError(t, err)
+		// Start returns without waiting for the webhook server to release its port.
+		testWaitClosed(net.JoinHostPort(testEnv.WebhookInstallOptions.LocalServingHost, strconv.Itoa(port)))
+	}()
+
+	return done
+}
+
+// TestEventuallyReconciled returns the index of the first non-zero counter or -1 when all counters stay zero.
+func testEventuallyReconciled(counters []int32) int {
+	for i := 0; i < 100; i++ {
+		for j := range counters {
+			if atomic.LoadInt32(&counters[j]) > 0 {
+				return j
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return -1
+}
+
+// TestFreePort returns a free TCP port.
+func testFreePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
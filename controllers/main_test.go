@@ -3,6 +3,7 @@ package controllers
 import (
 	"context"
 	"fmt"
+	vaultv1alpha1 "github.com/mmlt/vault-secret/api/v1alpha1"
 	"github.com/mmlt/vault-secret/pkg/mutator"
 	"github.com/mmlt/vault-secret/pkg/vault"
 	"github.com/stretchr/testify/assert"
@@ -13,13 +14,17 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	"net"
 	"os"
+	"path/filepath"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"strconv"
 	"testing"
+	"time"
 )
 
 // TestMain instantiates the following vars for usage in tests.
@@ -51,6 +56,7 @@ func TestMain(m *testing.M) {
 	testEnv = &envtest.Environment{
 		UseExistingCluster:    &useExistingCluster,
 		WebhookInstallOptions: webhookInstallOptions(WebhookPath),
+		CRDDirectoryPaths:     []string{filepath.Join("..", "config", "crd", "bases")},
 		//AttachControlPlaneOutput: true,
 		//KubeAPIServerFlags:    append(envtest.DefaultKubeAPIServerFlags, ),
 	}
@@ -61,6 +67,8 @@ func TestMain(m *testing.M) {
 
 	err = corev1.AddToScheme(scheme.Scheme)
 	mustNotErr("adding schema", err)
+	err = vaultv1alpha1.AddToScheme(scheme.Scheme)
+	mustNotErr("adding schema", err)
 
 	k8sClient, err = client.New(cfg, client.Options{Scheme: scheme.Scheme})
 	mustNotErr("creating client", err)
@@ -77,10 +85,18 @@ func TestMain(m *testing.M) {
 	os.Exit(r)
 }
 
-// TestStartManager starts a Manager with the provided vault.
-func testManager(t *testing.T, vault vault.Loginer, stop <-chan struct{}) {
+// TestStartManager starts a Manager with the provided vault as default backend.
+// The returned channel is closed when the Manager has stopped after stop is closed.
+func testManager(t *testing.T, backend vault.Loginer, stop <-chan struct{}) <-chan struct{} {
 	t.Helper()
 
+	backends := vault.NewBackends(backend)
+	settings := mutator.NewSettings(mutator.Templates{
+		VaultAuthPath:   "kubernetes",
+		VaultRole:       "vaultsecret-{ns}",
+		VaultSecretPath: "{p}",
+	})
+
 	// Setup manager (similar to main.go)
 
 	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
@@ -97,24 +113,60 @@ func testManager(t *testing.T, vault vault.Loginer, stop <-chan struct{}) {
 	hookServer := mgr.GetWebhookServer()
 	hookServer.Register(WebhookPath, &webhook.Admission{
 		Handler: &mutator.SecretMutator{
-			Vault:           vault,
-			VaultAuthPath:   "kubernetes",
-			VaultRole:       "vaultsecret-{ns}",
-			VaultSecretPath: "{p}",
-			Log:             logf.Log,
+			Backends: backends,
+			Settings: settings,
+			Log:      logf.Log,
 		},
 	})
 
+	// Setup VaultSecret controller.
+	err = (&VaultSecretReconciler{
+		Client:   mgr.GetClient(),
+		Log:      logf.Log,
+		Scheme:   mgr.GetScheme(),
+		Backends: backends,
+		Settings: settings,
+	}).SetupWithManager(mgr)
+	assert.NoError(t, err)
+
+	// Setup SecretPush controller.
+	err = (&SecretPushReconciler{
+		Client:   mgr.GetClient(),
+		Log:      logf.Log,
+		Backends: backends,
+		Settings: settings,
+	}).SetupWithManager(mgr)
+	assert.NoError(t, err)
+
 	// Start manager.
+	done := make(chan struct{})
 	go func() {
-		err = mgr.Start(stop)
+		defer close(done)
+		err := mgr.Start(stop)
 		mustNotErr("starting manager", err)
+		// Start returns without waiting for the webhook server to release its port.
+		testWaitClosed(net.JoinHostPort(testEnv.WebhookInstallOptions.LocalServingHost,
+			strconv.Itoa(testEnv.WebhookInstallOptions.LocalServingPort)))
 	}()
 
 	t.Log("waiting for webhook to be serving")
 	o := webhookInstallOptions(WebhookPath)
 	err = envtest.WaitForWebhooks(mgr.GetConfig(), o.MutatingWebhooks, o.ValidatingWebhooks, o)
 	assert.NoError(t, err)
+
+	return done
+}
+
+// TestWaitClosed waits (max 10s) until addr stops accepting connections.
+func testWaitClosed(addr string) {
+	for i := 0; i < 200; i++ {
+		c, err := net.DialTimeout("tcp", addr, 50*time.Millisecond)
+		if err != nil {
+			return
+		}
+		c.Close()
+		time.Sleep(50 * time.Millisecond)
+	}
 }
 
 // WebhookInstallOptions returns the options to configure a test environment.
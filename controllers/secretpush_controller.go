@@ -0,0 +1,167 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	"github.com/mmlt/vault-secret/pkg/mutator"
+	"github.com/mmlt/vault-secret/pkg/policy"
+	"github.com/mmlt/vault-secret/pkg/vault"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+const (
+	// PushPathAnnotation is the path in Vault to write Secret values to, relative to vault-secret-path.
+	PushPathAnnotation = "vault.mmlt.nl/push-path"
+	// PushFieldsAnnotation is a comma separated list of k8s secret field name = vault secret field name pairs.
+	// When not set all Secret fields are written using their k8s name.
+	PushFieldsAnnotation = "vault.mmlt.nl/push-fields"
+	// PushBackendAnnotation is the name of the backend to write to.
+	// Defaults to the backend selected by the namespace or the default backend.
+	PushBackendAnnotation = "vault.mmlt.nl/push-backend"
+)
+
+// SecretPushReconciler writes the values of Secrets annotated with vault.mmlt.nl/push-path to Vault.
+// The creator of a resource isn't authorized, see config.Config AuthorizeRequester.
+type SecretPushReconciler struct {
+	client.Client
+	Log logr.Logger
+
+	// Settings are the templates and policy that select the role and path.
+	Settings *mutator.Settings
+
+	// Backends are the vaults to write to, the Getter returned by Login must implement vault.Putter.
+	Backends *vault.Backends
+}
+
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+
+// Reconcile writes the selected values of a Secret to Vault.
+func (r *SecretPushReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("secret", req.NamespacedName)
+
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, req.NamespacedName, secret)
+	if err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	rpath := secret.Annotations[PushPathAnnotation]
+	if rpath == "" {
+		return ctrl.Result{}, nil
+	}
+
+	values := map[string]string{}
+	if fields, ok := secret.Annotations[PushFieldsAnnotation]; ok {
+		for k, f := range mutator.ParseFields(fields) {
+			if d, ok := secret.Data[k]; ok {
+				values[f] = string(d)
+			}
+		}
+	} else {
+		for k, d := range secret.Data {
+			values[k] = string(d)
+		}
+	}
+	if len(values) == 0 {
+		return ctrl.Result{}, nil
+	}
+
+	ts := r.Settings.Get()
+
+	backend, err := ts.TemplateEnv.BackendName(ctx, secret.Annotations[PushBackendAnnotation], secret.Namespace)
+	if err != nil {
+		log.Error(err, "push/backend")
+		return ctrl.Result{}, err
+	}
+	role, err := ts.TemplateEnv.Render(ctx, ts.VaultRole, secret, "")
+	if err != nil {
+		log.Error(err, "push/role")
+		return ctrl.Result{}, nil
+	}
+	path, err := ts.TemplateEnv.Render(ctx, r.Backends.SecretPath(backend, ts.VaultSecretPath), secret, rpath)
+	if err != nil {
+		log.Error(err, "push/path")
+		return ctrl.Result{}, nil
+	}
+
+	if ts.Policy != nil {
+		reason, err := ts.Policy.Check(ctx, policy.Request{
+			Namespace: secret.Namespace,
+			Backend:   backend,
+			Role:      role,
+			Path:      path,
+		})
+		if err != nil {
+			log.Error(err, "push/policy")
+			return ctrl.Result{}, err
+		}
+		if reason != "" {
+			log.Info("push/policy", "denied", reason)
+			return ctrl.Result{}, nil
+		}
+	}
+
+	b, err := r.Backends.Get(backend)
+	if err != nil {
+		log.Error(err, "push/backend")
+		return ctrl.Result{}, nil
+	}
+
+	c, err := b.Login(vault.LoginRequest{
+		AuthPath:  ts.VaultAuthPath,
+		Role:      role,
+		Namespace: secret.Namespace,
+	})
+	if err != nil {
+		log.Error(err, "push/login")
+		return ctrl.Result{}, err
+	}
+
+	w, ok := c.(vault.Putter)
+	if !ok {
+		err = fmt.Errorf("vault doesn't support writes")
+		log.Error(err, "push")
+		return ctrl.Result{}, nil
+	}
+
+	err = w.Put(path, values)
+	if err != nil {
+		log.Error(err, "push/put")
+		return ctrl.Result{}, err
+	}
+
+	log.Info("push", "role", role, "path", path, "fields", len(values))
+
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager registers the reconciler with mgr.
+func (r *SecretPushReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("secretpush").
+		For(&corev1.Secret{}).
+		WithEventFilter(hasAnnotation(PushPathAnnotation)).
+		Complete(r)
+}
+
+// HasAnnotation returns a predicate that filters out objects without annotation key.
+func hasAnnotation(key string) predicate.Predicate {
+	f := func(m metav1.Object) bool {
+		_, ok := m.GetAnnotations()[key]
+		return ok
+	}
+	return predicate.Funcs{
+		CreateFunc:  func(e event.CreateEvent) bool { return f(e.Meta) },
+		UpdateFunc:  func(e event.UpdateEvent) bool { return f(e.MetaNew) },
+		DeleteFunc:  func(e event.DeleteEvent) bool { return false },
+		GenericFunc: func(e event.GenericEvent) bool { return f(e.Meta) },
+	}
+}
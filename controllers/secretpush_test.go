@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"github.com/mmlt/testr"
+	"github.com/mmlt/vault-secret/pkg/vault"
+	"github.com/stretchr/testify/assert"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSecretPushFakevault(t *testing.T) {
+	stop := make(chan struct{})
+
+	logf.SetLogger(testr.New(t))
+
+	v := &fakePushVault{data: map[string]map[string]string{}}
+	done := testManager(t, v, stop)
+
+	t.Run("should_write_selected_fields_to_vault", func(t *testing.T) {
+		testCreateSecret(t, map[string]string{
+			"vault.mmlt.nl/push-path":   "path/to/secret",
+			"vault.mmlt.nl/push-fields": "een=one",
+		}, map[string][]byte{
+			"een":  []byte("first-value"),
+			"twee": []byte("second-value"),
+		})
+		assert.Eventually(t, func() bool {
+			return v.get("path/to/secret")["one"] == "first-value"
+		}, 5*time.Second, 100*time.Millisecond)
+		assert.Equal(t, map[string]string{"one": "first-value"}, v.get("path/to/secret"))
+	})
+
+	t.Run("should_write_all_fields_when_push-fields_is_missing", func(t *testing.T) {
+		testCreateSecret(t, map[string]string{
+			"vault.mmlt.nl/push-path": "path/to/other",
+		}, map[string][]byte{
+			"een":  []byte("first-value"),
+			"twee": []byte("second-value"),
+		})
+		assert.Eventually(t, func() bool {
+			return len(v.get("path/to/other")) == 2
+		}, 5*time.Second, 100*time.Millisecond)
+	})
+
+	// teardown manager
+	close(stop)
+	<-done
+}
+
+// FakePushVault is a vault.Loginer that records values written with Put.
+type fakePushVault struct {
+	sync.Mutex
+	data map[string]map[string]string
+}
+
+func (v *fakePushVault) Login(_ vault.LoginRequest) (vault.Getter, error) {
+	return v, nil
+}
+
+func (v *fakePushVault) Get(path string) (map[string]string, error) {
+	return v.get(path), nil
+}
+
+func (v *fakePushVault) Put(path string, values map[string]string) error {
+	v.Lock()
+	defer v.Unlock()
+	d, ok := v.data[path]
+	if !ok {
+		d = map[string]string{}
+		v.data[path] = d
+	}
+	for k, s := range values {
+		d[k] = s
+	}
+	return nil
+}
+
+func (v *fakePushVault) get(path string) map[string]string {
+	v.Lock()
+	defer v.Unlock()
+	r := map[string]string{}
+	for k, s := range v.data[path] {
+		r[k] = s
+	}
+	return r
+}
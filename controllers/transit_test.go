@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"fmt"
+	"github.com/mmlt/testr"
+	"github.com/mmlt/vault-secret/pkg/vault"
+	"github.com/stretchr/testify/assert"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"strings"
+	"testing"
+)
+
+func TestTransit(t *testing.T) {
+	stop := make(chan struct{})
+
+	logf.SetLogger(testr.New(t))
+
+	done := testManager(t, fakeTransit{
+		fakeVault: fakeVault{"one": "first-value"},
+		key:       "app",
+	}, stop)
+
+	t.Run("should_decrypt_ciphertext_values", func(t *testing.T) {
+		testCreateSecret(t, map[string]string{
+			"vault.mmlt.nl/transit-key": "app",
+		}, map[string][]byte{
+			"password":        []byte("vault:v1:secret"),
+			"shouldNotChange": []byte("value"),
+		})
+		got := testGetSecret(t)
+		assert.Equal(t, map[string]string{
+			"password":        "secret",
+			"shouldNotChange": "value",
+		}, msb2mss(got.Data))
+	})
+
+	t.Run("should_decrypt_and_inject", func(t *testing.T) {
+		testCreateSecret(t, map[string]string{
+			"vault.mmlt.nl/transit-key":   "app",
+			"vault.mmlt.nl/inject":        "true",
+			"vault.mmlt.nl/inject-path":   "path/to/secret",
+			"vault.mmlt.nl/inject-fields": "een=one",
+		}, map[string][]byte{
+			"password": []byte("vault:v1:secret"),
+		})
+		got := testGetSecret(t)
+		assert.Equal(t, map[string]string{
+			"password": "secret",
+			"een":      "first-value",
+		}, msb2mss(got.Data))
+	})
+
+	t.Run("should_reject_Secret_when_decrypt_fails", func(t *testing.T) {
+		testDeleteSecret(t)
+		err := testCreateSecretErr(map[string]string{
+			"vault.mmlt.nl/transit-key": "other",
+		}, map[string][]byte{
+			"password": []byte("vault:v1:secret"),
+		})
+		assert.Error(t, err)
+	})
+
+	// teardown manager
+	close(stop)
+	<-done
+}
+
+// FakeTransit is a fakeVault that decrypts "vault:v1:<plaintext>" values with key.
+type fakeTransit struct {
+	fakeVault
+	key string
+}
+
+func (v fakeTransit) Login(_ vault.LoginRequest) (vault.Getter, error) {
+	return v, nil
+}
+
+func (v fakeTransit) Decrypt(key string, ciphertexts map[string]string) (map[string]string, error) {
+	if key != v.key {
+		return nil, fmt.Errorf("unknown key %s", key)
+	}
+	r := make(map[string]string, len(ciphertexts))
+	for k, ct := range ciphertexts {
+		r[k] = strings.TrimPrefix(ct, "vault:v1:")
+	}
+	return r, nil
+}
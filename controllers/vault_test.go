@@ -14,7 +14,6 @@ import (
 	"net"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"testing"
-	"time"
 )
 
 // TestVault runs test cases against an existing k8s cluster ICW Vault running in memory.
@@ -50,7 +49,7 @@ func TestVault(t *testing.T) {
 
 	// Instantiate (webhook) manager.
 	stop := make(chan struct{})
-	testManager(t, client, stop)
+	done := testManager(t, client, stop)
 
 	t.Run("should_get_data_fields_from_vault_kv", func(t *testing.T) {
 		testCreateSecret(t, map[string]string{
@@ -87,7 +86,7 @@ func TestVault(t *testing.T) {
 
 	// teardown manager
 	close(stop)
-	time.Sleep(time.Second) //TODO how to wait for manager shutdown?
+	<-done
 }
 
 // TestVaultExisting runs test cases against an existing k8s cluster running Vault.
@@ -114,7 +113,7 @@ func TestVaultExisting(t *testing.T) {
 
 	// Instantiate (webhook) manager.
 	stop := make(chan struct{})
-	testManager(t, client, stop)
+	done := testManager(t, client, stop)
 
 	t.Run("should_get_data_fields_from_vault", func(t *testing.T) {
 		testCreateSecret(t, map[string]string{
@@ -131,7 +130,7 @@ func TestVaultExisting(t *testing.T) {
 
 	// teardown manager
 	close(stop)
-	time.Sleep(time.Second) //TODO how to wait for manager shutdown?
+	<-done
 }
 
 // TestVaultCore starts a Vault core.
@@ -0,0 +1,257 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-logr/logr"
+	vaultv1alpha1 "github.com/mmlt/vault-secret/api/v1alpha1"
+	"github.com/mmlt/vault-secret/pkg/mutator"
+	"github.com/mmlt/vault-secret/pkg/policy"
+	"github.com/mmlt/vault-secret/pkg/vault"
+	"text/template"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// VaultSecretReconciler reconciles a VaultSecret object by generating a Secret with values read from Vault.
+// The creator of a resource isn't authorized, see config.Config AuthorizeRequester.
+type VaultSecretReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+
+	// Settings are the templates and policy that select the role and path.
+	Settings *mutator.Settings
+
+	// Backends are the vaults to read from.
+	Backends *vault.Backends
+}
+
+// +kubebuilder:rbac:groups=vault.mmlt.nl,resources=vaultsecrets,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=vault.mmlt.nl,resources=vaultsecrets/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch
+
+// Reconcile reads the Vault paths of a VaultSecret and creates or updates the target Secret.
+func (r *VaultSecretReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("vaultsecret", req.NamespacedName)
+
+	vs := &vaultv1alpha1.VaultSecret{}
+	err := r.Get(ctx, req.NamespacedName, vs)
+	if err != nil {
+		// Not found errors are ignored, the generated Secret is garbage collected via its owner reference.
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	data, err := r.read(ctx, vs)
+	if err != nil {
+		log.Error(err, "read")
+		return ctrl.Result{}, r.updateStatus(ctx, vs, "ReadFailed", err)
+	}
+
+	err = r.writeSecret(ctx, vs, data)
+	if err != nil {
+		log.Error(err, "write")
+		return ctrl.Result{}, r.updateStatus(ctx, vs, "WriteFailed", err)
+	}
+
+	err = r.updateStatus(ctx, vs, "Synced", nil)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	log.V(1).Info("synced", "secret", vs.TargetName(), "fields", len(data))
+
+	var result ctrl.Result
+	if vs.Spec.RefreshInterval != nil {
+		result.RequeueAfter = vs.Spec.RefreshInterval.Duration
+	}
+	return result, nil
+}
+
+// Read returns the Secret data for a VaultSecret.
+func (r *VaultSecretReconciler) read(ctx context.Context, vs *vaultv1alpha1.VaultSecret) (map[string][]byte, error) {
+	ts := r.Settings.Get()
+
+	backend, err := ts.TemplateEnv.BackendName(ctx, vs.Spec.Backend, vs.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	role, err := ts.TemplateEnv.Render(ctx, ts.VaultRole, vs, "")
+	if err != nil {
+		return nil, err
+	}
+	tmpl := r.Backends.SecretPath(backend, ts.VaultSecretPath)
+	paths := make([]string, len(vs.Spec.Sources))
+	for i, src := range vs.Spec.Sources {
+		paths[i], err = ts.TemplateEnv.Render(ctx, tmpl, vs, src.Path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if ts.Policy != nil {
+		for _, path := range paths {
+			reason, err := ts.Policy.Check(ctx, policy.Request{
+				Namespace: vs.Namespace,
+				Backend:   backend,
+				Role:      role,
+				Path:      path,
+			})
+			if err != nil {
+				return nil, err
+			}
+			if reason != "" {
+				return nil, errors.New(reason)
+			}
+		}
+	}
+
+	b, err := r.Backends.Get(backend)
+	if err != nil {
+		return nil, err
+	}
+	if inv, ok := b.(vault.Invalidator); ok && vs.Status.ObservedGeneration == vs.Generation {
+		// a refresh reads the backend, cached values are only used for the first sync of a (changed) VaultSecret.
+		for _, path := range paths {
+			inv.Invalidate(path)
+		}
+	}
+
+	c, err := b.Login(vault.LoginRequest{
+		AuthPath:  ts.VaultAuthPath,
+		Role:      role,
+		Namespace: vs.Namespace,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("login: %w", err)
+	}
+
+	data := map[string][]byte{}
+	values := make(map[string]map[string]string, len(vs.Spec.Sources))
+	for i, src := range vs.Spec.Sources {
+		path := paths[i]
+		v, err := c.Get(path)
+		if err != nil {
+			return nil, fmt.Errorf("get %s: %w", path, err)
+		}
+
+		name := src.Name
+		if name == "" {
+			name = fmt.Sprintf("source%d", i)
+		}
+		values[name] = v
+
+		for k, f := range src.Fields {
+			if d, ok := v[f]; ok {
+				data[k] = []byte(d)
+			}
+		}
+	}
+
+	for k, t := range vs.Spec.Templates {
+		tmpl, err := template.New(k).Option("missingkey=error").Parse(t)
+		if err != nil {
+			return nil, fmt.Errorf("template %s: %w", k, err)
+		}
+		var b bytes.Buffer
+		err = tmpl.Execute(&b, values)
+		if err != nil {
+			return nil, fmt.Errorf("template %s: %w", k, err)
+		}
+		data[k] = b.Bytes()
+	}
+
+	return data, nil
+}
+
+// WriteSecret creates or updates the target Secret of a VaultSecret.
+func (r *VaultSecretReconciler) writeSecret(ctx context.Context, vs *vaultv1alpha1.VaultSecret, data map[string][]byte) error {
+	secret := &corev1.Secret{}
+	secret.Namespace = vs.Namespace
+	secret.Name = vs.TargetName()
+
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		owner := metav1.GetControllerOf(secret)
+		if owner != nil && owner.UID != vs.UID {
+			return fmt.Errorf("secret %s is controlled by %s %s", secret.Name, owner.Kind, owner.Name)
+		}
+		if owner == nil && !secret.CreationTimestamp.IsZero() {
+			// never adopt (and overwrite) a Secret that was created by someone else.
+			return fmt.Errorf("secret %s already exists and is not controlled by this VaultSecret", secret.Name)
+		}
+		if secret.CreationTimestamp.IsZero() {
+			secret.Type = vs.Spec.Target.Type
+			if secret.Type == "" {
+				secret.Type = corev1.SecretTypeOpaque
+			}
+		}
+		secret.Labels = vs.Spec.Target.Labels
+		secret.Annotations = vs.Spec.Target.Annotations
+		secret.Data = data
+		return controllerutil.SetControllerReference(vs, secret, r.Scheme)
+	})
+	return err
+}
+
+// UpdateStatus sets the Ready condition of a VaultSecret to reflect err.
+// When err is not nil it is returned (so the request is retried).
+func (r *VaultSecretReconciler) updateStatus(ctx context.Context, vs *vaultv1alpha1.VaultSecret, reason string, err error) error {
+	now := metav1.NewTime(time.Now())
+	c := vaultv1alpha1.VaultSecretCondition{
+		Type:               vaultv1alpha1.VaultSecretReady,
+		Status:             corev1.ConditionTrue,
+		LastTransitionTime: now,
+		Reason:             reason,
+	}
+	if err != nil {
+		c.Status = corev1.ConditionFalse
+		c.Message = err.Error()
+	} else {
+		vs.Status.ObservedGeneration = vs.Generation
+		vs.Status.LastSyncTime = &now
+	}
+	vs.Status.SetCondition(c)
+
+	uerr := r.Status().Update(ctx, vs)
+	if apierrors.IsConflict(uerr) {
+		// VaultSecret has changed, a new reconcile request is on its way.
+		uerr = nil
+	}
+	if err != nil {
+		return err
+	}
+	return uerr
+}
+
+// SetupWithManager registers the reconciler with mgr.
+// Only spec changes of a VaultSecret trigger a reconcile, otherwise each status update would trigger another read of
+// Vault. Refreshes are scheduled by RefreshInterval.
+func (r *VaultSecretReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	c, err := controller.New("vaultsecret", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+	err = c.Watch(&source.Kind{Type: &vaultv1alpha1.VaultSecret{}}, &handler.EnqueueRequestForObject{},
+		predicate.GenerationChangedPredicate{})
+	if err != nil {
+		return err
+	}
+	return c.Watch(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestForOwner{
+		OwnerType:    &vaultv1alpha1.VaultSecret{},
+		IsController: true,
+	})
+}
@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"github.com/mmlt/testr"
+	vaultv1alpha1 "github.com/mmlt/vault-secret/api/v1alpha1"
+	"github.com/mmlt/vault-secret/pkg/vault"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestVaultSecretFakevault(t *testing.T) {
+	stop := make(chan struct{})
+
+	logf.SetLogger(testr.New(t))
+
+	done := testManager(t, fakeVault(map[string]string{
+		"one": "first-value",
+		"two": "second-value",
+	}), stop)
+
+	target := types.NamespacedName{Namespace: testNSN.Namespace, Name: "test-generated"}
+
+	t.Run("should_generate_Secret_with_fields_and_templates", func(t *testing.T) {
+		testDeleteSecretNSN(t, target)
+		testCreateVaultSecret(t, vaultv1alpha1.VaultSecretSpec{
+			Sources: []vaultv1alpha1.VaultSecretSource{
+				{
+					Name: "src",
+					Path: "path/to/secret",
+					Fields: map[string]string{
+						"een": "one",
+					},
+				},
+			},
+			Templates: map[string]string{
+				"both": "{{ .src.one }}:{{ .src.two }}",
+			},
+			Target: vaultv1alpha1.VaultSecretTarget{
+				Name:   target.Name,
+				Labels: map[string]string{"app": "test"},
+			},
+		})
+		got := testEventuallyGetSecret(t, target)
+		assert.Equal(t, map[string]string{
+			"een":  "first-value",
+			"both": "first-value:second-value",
+		}, msb2mss(got.Data))
+		assert.Equal(t, corev1.SecretTypeOpaque, got.Type)
+		assert.Equal(t, "test", got.Labels["app"])
+		if assert.Len(t, got.OwnerReferences, 1) {
+			assert.Equal(t, "VaultSecret", got.OwnerReferences[0].Kind)
+		}
+	})
+
+	t.Run("should_not_take_over_Secret_that_is_not_owned", func(t *testing.T) {
+		testCreateSecret(t, nil, map[string][]byte{
+			"shouldNotChange": []byte("value"),
+		})
+		testCreateVaultSecret(t, vaultv1alpha1.VaultSecretSpec{
+			Sources: []vaultv1alpha1.VaultSecretSource{
+				{
+					Path:   "path/to/secret",
+					Fields: map[string]string{"een": "one"},
+				},
+			},
+		})
+		time.Sleep(time.Second)
+		got := testGetSecret(t)
+		assert.Equal(t, map[string]string{
+			"shouldNotChange": "value",
+		}, msb2mss(got.Data))
+		assert.Empty(t, got.OwnerReferences)
+
+		vs := &vaultv1alpha1.VaultSecret{}
+		err := k8sClient.Get(testCtx, testNSN, vs)
+		if assert.NoError(t, err) && assert.Len(t, vs.Status.Conditions, 1) {
+			assert.Equal(t, corev1.ConditionFalse, vs.Status.Conditions[0].Status)
+			assert.Equal(t, "WriteFailed", vs.Status.Conditions[0].Reason)
+		}
+	})
+
+	testDeleteVaultSecret(t)
+	testDeleteSecretNSN(t, target)
+
+	// teardown manager
+	close(stop)
+	<-done
+}
+
+// CountingVault is a fakeVault that counts reads.
+type countingVault struct {
+	fakeVault
+	reads int32
+}
+
+func (v *countingVault) Login(_ vault.LoginRequest) (vault.Getter, error) {
+	return v, nil
+}
+
+func (v *countingVault) Get(path string) (map[string]string, error) {
+	atomic.AddInt32(&v.reads, 1)
+	return v.fakeVault.Get(path)
+}
+
+func TestVaultSecretReads(t *testing.T) {
+	stop := make(chan struct{})
+
+	logf.SetLogger(testr.New(t))
+
+	backend := &countingVault{fakeVault: fakeVault{"one": "first-value"}}
+	done := testManager(t, backend, stop)
+
+	target := types.NamespacedName{Namespace: testNSN.Namespace, Name: "test-reads"}
+
+	t.Run("should_not_read_vault_on_status_updates", func(t *testing.T) {
+		testDeleteSecretNSN(t, target)
+		testCreateVaultSecret(t, vaultv1alpha1.VaultSecretSpec{
+			Sources: []vaultv1alpha1.VaultSecretSource{
+				{Path: "path/to/secret", Fields: map[string]string{"een": "one"}},
+			},
+			Target: vaultv1alpha1.VaultSecretTarget{Name: target.Name},
+		})
+		testEventuallyGetSecret(t, target)
+
+		time.Sleep(3 * time.Second)
+		// the first sync and a sync caused by the change of the generated Secret.
+		assert.LessOrEqual(t, atomic.LoadInt32(&backend.reads), int32(2))
+	})
+
+	testDeleteVaultSecret(t)
+	testDeleteSecretNSN(t, target)
+
+	// teardown manager
+	close(stop)
+	<-done
+}
@@ -0,0 +1,60 @@
+package controllers
+
+import (
+	"github.com/mmlt/testr"
+	"github.com/mmlt/vault-secret/pkg/mutator"
+	"github.com/mmlt/vault-secret/pkg/vault"
+	"github.com/stretchr/testify/assert"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"testing"
+	"time"
+)
+
+func TestWrap(t *testing.T) {
+	stop := make(chan struct{})
+
+	logf.SetLogger(testr.New(t))
+
+	done := testManager(t, fakeWrap{"one": "first-value"}, stop)
+
+	t.Run("should_store_wrapping_token_only", func(t *testing.T) {
+		testCreateSecret(t, map[string]string{
+			"vault.mmlt.nl/inject":      "true",
+			"vault.mmlt.nl/inject-path": "path/to/secret",
+			"vault.mmlt.nl/wrap-ttl":    "5m",
+		}, nil)
+		got := testGetSecret(t)
+		assert.Equal(t, map[string]string{
+			mutator.WrappingTokenKey: "s.wrapped-path/to/secret-5m0s",
+		}, msb2mss(got.Data))
+	})
+
+	t.Run("should_reject_Secret_with_invalid_wrap-ttl", func(t *testing.T) {
+		testDeleteSecret(t)
+		err := testCreateSecretErr(map[string]string{
+			"vault.mmlt.nl/inject":      "true",
+			"vault.mmlt.nl/inject-path": "path/to/secret",
+			"vault.mmlt.nl/wrap-ttl":    "5",
+		}, nil)
+		assert.Error(t, err)
+	})
+
+	// teardown manager
+	close(stop)
+	<-done
+}
+
+// FakeWrap is a fakeVault that returns wrapping tokens containing path and TTL.
+type fakeWrap map[string]string
+
+func (v fakeWrap) Login(_ vault.LoginRequest) (vault.Getter, error) {
+	return v, nil
+}
+
+func (v fakeWrap) Get(_ string) (map[string]string, error) {
+	return v, nil
+}
+
+func (v fakeWrap) Wrap(path string, ttl time.Duration) (string, error) {
+	return "s.wrapped-" + path + "-" + ttl.String(), nil
+}
@@ -17,15 +17,40 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	vaultv1alpha1 "github.com/mmlt/vault-secret/api/v1alpha1"
 	"github.com/mmlt/vault-secret/controllers"
+	"github.com/mmlt/vault-secret/pkg/config"
+	"github.com/mmlt/vault-secret/pkg/health"
+	"github.com/mmlt/vault-secret/pkg/identity"
 	"github.com/mmlt/vault-secret/pkg/mutator"
+	"github.com/mmlt/vault-secret/pkg/policy"
+	"github.com/mmlt/vault-secret/pkg/shutdown"
+	"github.com/mmlt/vault-secret/pkg/vault"
+	_ "github.com/mmlt/vault-secret/pkg/vault/aws"
+	_ "github.com/mmlt/vault-secret/pkg/vault/azure"
+	"github.com/mmlt/vault-secret/pkg/vault/breaker"
+	"github.com/mmlt/vault-secret/pkg/vault/cache"
+	_ "github.com/mmlt/vault-secret/pkg/vault/file"
+	_ "github.com/mmlt/vault-secret/pkg/vault/gcp"
 	"github.com/mmlt/vault-secret/pkg/vault/hashivault"
+	"github.com/mmlt/vault-secret/pkg/vault/kubernetes"
+	_ "github.com/mmlt/vault-secret/pkg/vault/sops"
+	"github.com/mmlt/vault-secret/pkg/webhookcert"
+	"github.com/mmlt/vault-secret/pkg/webhookconfig"
 	"io/ioutil"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"os"
 	"path/filepath"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"strings"
 
 	//_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -34,6 +59,7 @@ import (
 )
 
 var (
+	scheme   = runtime.NewScheme()
 	setupLog = ctrl.Log.WithName("setup")
 
 	usage = `%[1]s %[2]s
@@ -43,6 +69,34 @@ Secret annotations:
   vault.mmlt.nl/inject="true" - Enable the injection of data fields. This should be set to a true or false value. Defaults to false.
   vault.mmlt.nl/inject-path="path/to/secret" - The path in Vault where the secret is located relative to vault-secret-path.
   vault.mmlt.nl/inject-fields="user=name,pw=password" - A comma separated list of k8s secret field name = vault secret field name pairs.
+  vault.mmlt.nl/inject-backend="name" - The name of the backend to read from (see --config). Defaults to the vault.mmlt.nl/backend label of the namespace or the Vault at --vault-url.
+  vault.mmlt.nl/service-account="name" - Login to Vault as this ServiceAccount in the namespace of the Secret (when --enable-impersonation is set).
+  vault.mmlt.nl/transit-key="name" - Decrypt data values that are Vault transit ciphertext (vault:v1:...) with this transit key (prefix with the mount path when not mounted at transit/).
+  vault.mmlt.nl/wrap-ttl="5m" - Store a response-wrapped token for inject-path in the wrapping_token field instead of the values (inject-fields are ignored).
+  vault.mmlt.nl/push-path="path/to/secret" - Write Secret values to this path in Vault (when --enable-push-controller is set).
+  vault.mmlt.nl/push-fields="user=name,pw=password" - A comma separated list of k8s secret field name = vault secret field name pairs to write. Defaults to all fields.
+  vault.mmlt.nl/push-backend="name" - The name of the backend to write to. Defaults to the Vault at --vault-url.
+
+Secret labels:
+  vault.mmlt.nl/inject="true" - Same as the annotation, required to reach the webhook when it's registered with the default objectSelector (--enable-webhook-registration).
+
+VaultSecret resources (when --enable-vaultsecret-controller is set):
+  A VaultSecret (vault.mmlt.nl/v1alpha1) describes the Vault paths, field mappings and templates of a Secret.
+  The controller generates and owns the target Secret.
+
+VaultSecretPolicy resources (when --enable-policies is set):
+  A cluster-scoped VaultSecretPolicy (vault.mmlt.nl/v1alpha1) lists the Vault paths, roles and backends that the
+  namespaces it selects are allowed to use. Requests that aren't allowed by any policy are denied.
+
+Templates:
+  --vault-role and --vault-secret-path are templates with {variable} or {variable|function|function} references.
+  Variables: ns, n (name), p (path), cluster, label:<key>, annotation:<key> (of the Secret or VaultSecret),
+  nslabel:<key>, nsannotation:<key> (of the namespace).
+  Functions: lower, upper, trunc:<length>, default:<value>.
+  For example "secret/{nslabel:team|lower|default:shared}/{ns}/{p}"
+  The path (p) must be relative without ".", ".." elements, template references or control characters.
+  --vault-secret-path applies to the default and hashivault backends, other backends use the path as is unless
+  their secretPath is set in --config.
 
 Commandline flags:
 `
@@ -50,31 +104,86 @@ Commandline flags:
 	Version string
 )
 
+func init() {
+	_ = clientgoscheme.AddToScheme(scheme)
+
+	_ = vaultv1alpha1.AddToScheme(scheme)
+	// +kubebuilder:scaffold:scheme
+}
+
 func main() {
-	vaultURL := flag.String("vault-url", "https://vault.example.com",
-		"The URL of the Vault server")
-	vaultCAFile := flag.String("vault-ca-file", "",
+	def := config.Default()
+	configFile := flag.String("config", "",
+		"The path of a YAML configuration file, see README. Changes to backends and the Vault connection are applied without restart.\n"+
+			"Command line flags override values in the file.")
+	vaultURL := flag.String("vault-url", def.Vault.URL,
+		"The URL of the Vault server or a comma separated list of URLs of the nodes of a Vault cluster")
+	vaultCAFile := flag.String("vault-ca-file", def.Vault.CAFile,
 		"The path of the Vault server CA")
-	vaultTLSInsecure := flag.Bool("vault-tls-insecure", false,
+	vaultTLSInsecure := flag.Bool("vault-tls-insecure", def.Vault.TLSInsecure,
 		"Allow insecure TLS connections")
-	vaultAuthPath := flag.String("vault-auth-path", "kubernetes",
+	vaultAuthPath := flag.String("vault-auth-path", def.Vault.AuthPath,
 		"The path of the Vault kubeauth credential backend mount")
-	vaultRole := flag.String("vault-role", "vaultsecret-{ns}",
-		"The template that results in a role name. Arguments: {ns} for namespace, {n} for name. \n"+
+	vaultRole := flag.String("vault-role", def.Vault.Role,
+		"The template that results in a role name (see Templates below).\n"+
 			"for example \"vaultsecret-{ns}\" produces \"vaultsecret-default\" when the Secret is in namespace \"default\"")
-	vaultSecretPath := flag.String("vault-secret-path", "{p}",
-		"The template that results in a Vault path.\n"+
-			"Arguments: {ns} for namespace, {n} for name, {p} for the vault.mmlt.nl/inject-path annotation value")
+	vaultSecretPath := flag.String("vault-secret-path", def.Vault.SecretPath,
+		"The template that results in a Vault path (see Templates below).\n"+
+			"{p} is the vault.mmlt.nl/inject-path annotation value")
+	clusterName := flag.String("cluster-name", def.ClusterName,
+		"The name of the cluster, available as {cluster} in templates.")
+	backendsConfig := flag.String("backends-config", "",
+		"Deprecated, use --config. The path of a YAML file with additional named backends, for example:\n"+
+			"backends:\n- name: files\n  type: file\n  options:\n    dir: /etc/secrets\n"+
+			"Backend types: "+strings.Join(vault.Types(), ", "))
 	metricsAddr := flag.String("metrics-addr", ":8080",
 		"The address the metric endpoint binds to.")
+	healthProbeAddr := flag.String("health-probe-addr", ":8081",
+		"The address the /healthz and /readyz endpoints bind to.")
 	webhookCertDir := flag.String("webhook-cert-dir", "/var/run/webhook",
 		"The directory containing the webhook server tls.key and tls.crt files.")
 	webhookPort := flag.Int("webhook-port", 9443,
 		"The port the webhook server binds to.")
+	enableVaultSecretController := flag.Bool("enable-vaultsecret-controller", def.Controllers.VaultSecret,
+		"Enable the controller that generates Secrets from VaultSecret resources (requires the VaultSecret CRD to be installed).")
+	enablePolicies := flag.Bool("enable-policies", def.Policies,
+		"Require Vault access to be allowed by a VaultSecretPolicy that selects the namespace (requires the VaultSecretPolicy CRD to be installed).")
+	enableImpersonation := flag.Bool("enable-impersonation", def.Impersonation.Enabled,
+		"Allow Secrets to login to Vault as the ServiceAccount named by the vault.mmlt.nl/service-account annotation.\n"+
+			"The requester must be allowed to create tokens for the ServiceAccount.")
+	impersonationAudiences := flag.String("impersonation-audiences", "",
+		"A comma separated list of audiences of impersonated ServiceAccount tokens. Defaults to the API server audience.")
+	authorizeRequester := flag.String("authorize-requester", def.AuthorizeRequester,
+		"Check with a SubjectAccessReview that the requester is allowed a verb on a resource named after the Vault path.\n"+
+			"The value is verb:resource.group, for example inject:vaultpaths.vault.mmlt.nl. Defaults to no check.\n"+
+			"Can't be combined with the VaultSecret and push controllers, they don't authorize the creator of a resource.")
+	enablePushController := flag.Bool("enable-push-controller", def.Controllers.Push,
+		"Enable the controller that writes values of Secrets annotated with vault.mmlt.nl/push-path to Vault.")
+	enableCache := flag.Bool("enable-cache", def.Cache.Enabled,
+		"Cache values read from backends in memory, see the cache section of the --config file for TTLs.")
+	enableCircuitBreaker := flag.Bool("enable-circuit-breaker", def.CircuitBreaker.Enabled,
+		"Reject requests to a backend that fails, see the circuitBreaker section of the --config file for thresholds and limits.")
+	enableLeaderElection := flag.Bool("enable-leader-election", def.LeaderElection.Enabled,
+		"Enable leader election, only the leader runs the controllers while all replicas serve the webhook.")
+	leaderElectionID := flag.String("leader-election-id", def.LeaderElection.ID,
+		"The name of the ConfigMap that holds the leader lock.")
+	leaderElectionNamespace := flag.String("leader-election-namespace", def.LeaderElection.Namespace,
+		"The namespace of the leader lock ConfigMap. Defaults to the namespace vault-secret runs in.")
+	shutdownDelay := flag.Duration("shutdown-delay", def.Shutdown.Delay.Duration,
+		"The time between receiving SIGTERM and shutting down, admission requests are served during this time.")
+	shutdownTimeout := flag.Duration("shutdown-timeout", def.Shutdown.Timeout.Duration,
+		"The maximum time to wait for in-flight admission requests to complete on shutdown.")
+	enableWebhookCerts := flag.Bool("enable-webhook-certs", def.Webhook.Certs.Enabled,
+		"Generate and rotate a self-signed webhook certificate instead of using the one in --webhook-cert-dir provided by for example cert-manager.")
+	enableWebhookRegistration := flag.Bool("enable-webhook-registration", def.Webhook.Registration.Enabled,
+		"Create or update the MutatingWebhookConfiguration on startup, see the webhook section of the --config file for selectors and policies.")
+	webhookCertSecret := flag.String("webhook-cert-secret", def.Webhook.Certs.SecretName,
+		"The name of the Secret that stores the self-signed webhook CA and certificate (when --enable-webhook-certs is set).")
+	webhookServiceName := flag.String("webhook-service-name", def.Webhook.ServiceName,
+		"The name of the webhook Service (when --enable-webhook-certs or --enable-webhook-registration is set).")
+	webhookConfigurationName := flag.String("webhook-configuration-name", def.Webhook.ConfigurationName,
+		"The name of the MutatingWebhookConfiguration (when --enable-webhook-certs or --enable-webhook-registration is set).")
 
-	//enableLeaderElection := flag.Bool("enable-leader-election", false,
-	//	"Enable leader election for controller manager. "+
-	//		"Enabling this will ensure there is only one active controller manager.")
 	flag.Usage = func() {
 		_, _ = fmt.Fprintf(os.Stderr, usage, filepath.Base(os.Args[0]), Version)
 		flag.PrintDefaults()
@@ -83,41 +192,323 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
 
+	if *configFile == "" {
+		// backends-config files are config files with only backends.
+		*configFile = *backendsConfig
+	}
+
+	// override applies the flags that are set on the command line to a config.
+	override := func(c *config.Config) {
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "vault-url":
+				c.Vault.URL = *vaultURL
+			case "vault-ca-file":
+				c.Vault.CAFile = *vaultCAFile
+			case "vault-tls-insecure":
+				c.Vault.TLSInsecure = *vaultTLSInsecure
+			case "vault-auth-path":
+				c.Vault.AuthPath = *vaultAuthPath
+			case "vault-role":
+				c.Vault.Role = *vaultRole
+			case "vault-secret-path":
+				c.Vault.SecretPath = *vaultSecretPath
+			case "cluster-name":
+				c.ClusterName = *clusterName
+			case "enable-vaultsecret-controller":
+				c.Controllers.VaultSecret = *enableVaultSecretController
+			case "enable-push-controller":
+				c.Controllers.Push = *enablePushController
+			case "enable-cache":
+				c.Cache.Enabled = *enableCache
+			case "enable-circuit-breaker":
+				c.CircuitBreaker.Enabled = *enableCircuitBreaker
+			case "enable-policies":
+				c.Policies = *enablePolicies
+			case "enable-impersonation":
+				c.Impersonation.Enabled = *enableImpersonation
+			case "impersonation-audiences":
+				c.Impersonation.Audiences = nil
+				if *impersonationAudiences != "" {
+					c.Impersonation.Audiences = strings.Split(*impersonationAudiences, ",")
+				}
+			case "authorize-requester":
+				c.AuthorizeRequester = *authorizeRequester
+			case "enable-leader-election":
+				c.LeaderElection.Enabled = *enableLeaderElection
+			case "leader-election-id":
+				c.LeaderElection.ID = *leaderElectionID
+			case "leader-election-namespace":
+				c.LeaderElection.Namespace = *leaderElectionNamespace
+			case "shutdown-delay":
+				c.Shutdown.Delay = metav1.Duration{Duration: *shutdownDelay}
+			case "shutdown-timeout":
+				c.Shutdown.Timeout = metav1.Duration{Duration: *shutdownTimeout}
+			case "enable-webhook-certs":
+				c.Webhook.Certs.Enabled = *enableWebhookCerts
+			case "enable-webhook-registration":
+				c.Webhook.Registration.Enabled = *enableWebhookRegistration
+			case "webhook-cert-secret":
+				c.Webhook.Certs.SecretName = *webhookCertSecret
+			case "webhook-service-name":
+				c.Webhook.ServiceName = *webhookServiceName
+			case "webhook-configuration-name":
+				c.Webhook.ConfigurationName = *webhookConfigurationName
+			}
+		})
+	}
+
 	ctrl.Log.Info("starting", "version", Version)
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		//Scheme:             scheme,
-		MetricsBindAddress: *metricsAddr,
-		Port:               *webhookPort,
-		CertDir:            *webhookCertDir,
-		//LeaderElection:     enableLeaderElection,
-		//LeaderElectionID:   "c87fed36.mmlt.nl",
+	restConfig := ctrl.GetConfigOrDie()
+
+	// register before loading the config so the kubernetes backend type is known.
+	apiReader, err := client.New(restConfig, client.Options{Scheme: scheme})
+	exitWhenError("creating API reader", err)
+	kubernetes.Register(apiReader)
+
+	var cfg *config.Config
+	var watcher *config.Watcher
+	if *configFile != "" {
+		watcher = &config.Watcher{
+			File:     *configFile,
+			Override: override,
+			Log:      ctrl.Log.WithName("config"),
+		}
+		cfg, err = watcher.Load()
+	} else {
+		cfg, err = config.Load("", override)
+	}
+	exitWhenError("loading config", err)
+
+	mgr, err := ctrl.NewManager(restConfig, ctrl.Options{
+		Scheme:                  scheme,
+		MetricsBindAddress:      *metricsAddr,
+		HealthProbeBindAddress:  *healthProbeAddr,
+		Port:                    *webhookPort,
+		CertDir:                 *webhookCertDir,
+		LeaderElection:          cfg.LeaderElection.Enabled,
+		LeaderElectionID:        cfg.LeaderElection.ID,
+		LeaderElectionNamespace: cfg.LeaderElection.Namespace,
 	})
 	exitWhenError("creating manager", err)
 
-	var vaultCA []byte
-	if *vaultCAFile != "" {
-		vaultCA, err = ioutil.ReadFile(*vaultCAFile)
-		exitWhenError("reading vault-ca-file", err)
+	var webhookNamespace string
+	if cfg.Webhook.Certs.Enabled || cfg.Webhook.Registration.Enabled {
+		webhookNamespace, err = namespace(cfg.Webhook.Namespace)
+		exitWhenError("webhook namespace", err)
+	}
+
+	// register before creating certificates so the CA bundle is set on a new MutatingWebhookConfiguration.
+	if cfg.Webhook.Registration.Enabled {
+		err = registration(cfg, apiReader, webhookNamespace).Register(context.Background())
+		exitWhenError("registering webhook", err)
 	}
 
-	client, err := hashivault.New(*vaultURL, string(vaultCA), *vaultTLSInsecure)
+	if cfg.Webhook.Certs.Enabled && !cfg.Webhook.Registration.Enabled {
+		setupLog.Info("webhook certificates are enabled without webhook registration, the webhook configuration must not send Secrets in namespace to the webhook",
+			"namespace", webhookNamespace)
+	}
+	if cfg.Webhook.Certs.Enabled {
+		certs := &webhookcert.Manager{
+			Client:               apiReader,
+			Namespace:            webhookNamespace,
+			SecretName:           cfg.Webhook.Certs.SecretName,
+			ServiceName:          cfg.Webhook.ServiceName,
+			WebhookConfiguration: cfg.Webhook.ConfigurationName,
+			CertDir:              *webhookCertDir,
+			Validity:             cfg.Webhook.Certs.Validity.Duration,
+			RotateBefore:         cfg.Webhook.Certs.RotateBefore.Duration,
+			Log:                  ctrl.Log.WithName("webhookcert"),
+		}
+		// the webhook server needs a certificate when it starts.
+		err = certs.Ensure(context.Background())
+		exitWhenError("creating webhook certificate", err)
+		err = mgr.Add(certs)
+		exitWhenError("adding webhook certificate manager", err)
+	}
+
+	vaultClient, err := newVault(cfg.Vault)
 	exitWhenError("creating Vault client", err)
 
+	backends := vault.NewBackends(nil)
+	backends.SetDecorator(decorator(cfg))
+	err = backends.Replace(vaultClient, cfg.Backends)
+	exitWhenError("configuring backends", err)
+
+	settings := mutator.NewSettings(templates(cfg, mgr.GetClient()))
+
+	if watcher != nil {
+		watcher.OnChange = func(c *config.Config) error {
+			if c.RestartRequired(cfg) {
+				setupLog.Info("config changes other than backends, vault connection, templates, policies and webhook registration require a restart")
+			}
+			// create everything that can fail before changing the backends and settings in use.
+			vaultClient, err := newVault(c.Vault)
+			if err != nil {
+				return err
+			}
+			staged, err := backends.Stage(vaultClient, c.Backends, decorator(c))
+			if err != nil {
+				return err
+			}
+			if cfg.Webhook.Registration.Enabled && c.Webhook.Registration.Enabled {
+				err = registration(c, apiReader, webhookNamespace).Register(context.Background())
+				if err != nil {
+					staged.Discard()
+					return err
+				}
+			}
+			staged.Commit()
+			settings.Replace(templates(c, mgr.GetClient()))
+			return nil
+		}
+		err = mgr.Add(watcher)
+		exitWhenError("watching config", err)
+	}
+
+	var impersonator *identity.Impersonator
+	if cfg.Impersonation.Enabled {
+		impersonator, err = identity.New(mgr.GetConfig(), cfg.Impersonation.Audiences)
+		exitWhenError("creating impersonator", err)
+	}
+
+	var authorizer *identity.Authorizer
+	if cfg.AuthorizeRequester != "" {
+		verb, gr, _ := cfg.RequesterAuthorization()
+		authorizer, err = identity.NewAuthorizer(mgr.GetConfig(), verb, gr.Group, gr.Resource)
+		exitWhenError("creating authorizer", err)
+	}
+
 	hookServer := mgr.GetWebhookServer()
+	drainer := &shutdown.Drainer{}
 	hookServer.Register(controllers.WebhookPath, &webhook.Admission{
-		Handler: &mutator.SecretMutator{
-			Vault:           client,
-			VaultAuthPath:   *vaultAuthPath,
-			VaultRole:       *vaultRole,
-			VaultSecretPath: *vaultSecretPath,
-			Log:             ctrl.Log,
-		},
+		Handler: drainer.Handler(&mutator.SecretMutator{
+			Backends:     backends,
+			Settings:     settings,
+			Impersonator: impersonator,
+			Authorizer:   authorizer,
+			Log:          ctrl.Log,
+		}),
 	})
 
+	if cfg.Controllers.VaultSecret {
+		err = (&controllers.VaultSecretReconciler{
+			Client:   mgr.GetClient(),
+			Log:      ctrl.Log.WithName("controllers").WithName("VaultSecret"),
+			Scheme:   mgr.GetScheme(),
+			Backends: backends,
+			Settings: settings,
+		}).SetupWithManager(mgr)
+		exitWhenError("creating VaultSecret controller", err)
+	}
+	if cfg.Controllers.Push {
+		err = (&controllers.SecretPushReconciler{
+			Client:   mgr.GetClient(),
+			Log:      ctrl.Log.WithName("controllers").WithName("SecretPush"),
+			Backends: backends,
+			Settings: settings,
+		}).SetupWithManager(mgr)
+		exitWhenError("creating SecretPush controller", err)
+	}
+	// +kubebuilder:scaffold:builder
+
+	err = mgr.AddHealthzCheck("ping", healthz.Ping)
+	exitWhenError("adding healthz check", err)
+	// a replica is ready when it can serve admission requests.
+	err = mgr.AddReadyzCheck("webhook-certs", health.Certs(*webhookCertDir))
+	exitWhenError("adding readyz check", err)
+	err = mgr.AddReadyzCheck("vault", health.Backend(backends, ""))
+	exitWhenError("adding readyz check", err)
+	err = mgr.AddReadyzCheck("shutdown", drainer.Check)
+	exitWhenError("adding readyz check", err)
+
 	setupLog.Info("starting manager")
-	err = mgr.Start(ctrl.SetupSignalHandler())
+	err = mgr.Start(drainer.SignalHandler(cfg.Shutdown.Delay.Duration))
 	exitWhenError("start manager", err)
+
+	// the webhook server stops accepting requests when the manager stops, wait for the requests it's serving.
+	if !drainer.Wait(cfg.Shutdown.Timeout.Duration) {
+		setupLog.Info("shutdown timeout, in-flight admission requests are aborted")
+	}
+}
+
+// Templates returns the templates and policy configured by c.
+func templates(c *config.Config, clnt client.Client) mutator.Templates {
+	t := mutator.Templates{
+		VaultAuthPath:   c.Vault.AuthPath,
+		VaultRole:       c.Vault.Role,
+		VaultSecretPath: c.Vault.SecretPath,
+		TemplateEnv: mutator.TemplateEnv{
+			Client:  clnt,
+			Cluster: c.ClusterName,
+		},
+	}
+	if c.Policies {
+		t.Policy = &policy.Checker{Client: clnt}
+	}
+	return t
+}
+
+// Registration returns the webhook registration configured by c.
+func registration(c *config.Config, clnt client.Client, namespace string) *webhookconfig.Registration {
+	r := c.Webhook.Registration
+	return &webhookconfig.Registration{
+		Client:             clnt,
+		Name:               c.Webhook.ConfigurationName,
+		Namespace:          namespace,
+		ServiceName:        c.Webhook.ServiceName,
+		Path:               controllers.WebhookPath,
+		ObjectSelector:     r.ObjectSelector,
+		ExcludeNamespaces:  r.ExcludeNamespaces,
+		Timeout:            r.Timeout.Duration,
+		ReinvocationPolicy: admissionregistrationv1.ReinvocationPolicyType(r.ReinvocationPolicy),
+		FailurePolicy:      admissionregistrationv1.FailurePolicyType(r.FailurePolicy),
+		Log:                ctrl.Log.WithName("webhookconfig"),
+	}
+}
+
+// Decorator returns the decorator of backends configured by c.
+// The circuit breaker is closest to the backend, its rejections wrap vault.ErrUnavailable so the cache serves stale
+// values while the circuit is open.
+func decorator(c *config.Config) vault.Decorator {
+	cb, ch := breaker.Decorator(c.CircuitBreaker), cache.Decorator(c.Cache)
+	return func(name string, l vault.Loginer) vault.Loginer {
+		if cb != nil {
+			l = cb(name, l)
+		}
+		if ch != nil {
+			l = ch(name, l)
+		}
+		return l
+	}
+}
+
+// Namespace returns ns or when empty the namespace vault-secret runs in.
+func namespace(ns string) (string, error) {
+	const namespacePath = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+	if ns != "" {
+		return ns, nil
+	}
+	b, err := ioutil.ReadFile(namespacePath)
+	if err != nil {
+		return "", fmt.Errorf("namespace not set and not running in-cluster: %w", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// NewVault returns the default backend.
+func newVault(c config.Vault) (vault.Loginer, error) {
+	var ca []byte
+	if c.CAFile != "" {
+		var err error
+		ca, err = ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading vault ca file: %w", err)
+		}
+	}
+	return hashivault.New(c.URL, string(ca), c.TLSInsecure)
 }
 
 func exitWhenError(msg string, err error) {
@@ -0,0 +1,348 @@
+// Package config loads the vault-secret configuration file.
+package config
+
+import (
+	"fmt"
+	"github.com/mmlt/vault-secret/pkg/mutator"
+	"github.com/mmlt/vault-secret/pkg/vault"
+	"github.com/mmlt/vault-secret/pkg/vault/breaker"
+	"github.com/mmlt/vault-secret/pkg/vault/cache"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+)
+
+// Version is the current version of the configuration file format.
+const Version = "v1"
+
+// Config is the configuration of vault-secret.
+// Fields marked (reload) are applied without restart when the file changes.
+type Config struct {
+	// Version of the file format, defaults to the current version.
+	Version string `json:"version,omitempty"`
+
+	// Vault is the default backend.
+	Vault Vault `json:"vault,omitempty"`
+
+	// ClusterName is the value of {cluster} in templates (reload).
+	ClusterName string `json:"clusterName,omitempty"`
+
+	// Backends are additional named backends (reload).
+	Backends []vault.BackendConfig `json:"backends,omitempty"`
+
+	// Cache of values read from backends (reload).
+	Cache cache.Config `json:"cache,omitempty"`
+
+	// CircuitBreaker and concurrency limit of backends (reload).
+	CircuitBreaker breaker.Config `json:"circuitBreaker,omitempty"`
+
+	// Policies requires Vault access to be allowed by a VaultSecretPolicy (reload).
+	Policies bool `json:"policies,omitempty"`
+
+	// Impersonation of ServiceAccounts with the vault.mmlt.nl/service-account annotation.
+	Impersonation Impersonation `json:"impersonation,omitempty"`
+
+	// AuthorizeRequester is verb:resource.group to check with a SubjectAccessReview, empty disables the check.
+	AuthorizeRequester string `json:"authorizeRequester,omitempty"`
+
+	// LeaderElection selects the replica that runs the controllers, all replicas serve the webhook.
+	LeaderElection LeaderElection `json:"leaderElection,omitempty"`
+
+	// Shutdown of a replica.
+	Shutdown Shutdown `json:"shutdown,omitempty"`
+
+	// Webhook endpoint, certificates and registration.
+	Webhook Webhook `json:"webhook,omitempty"`
+
+	// Controllers to run.
+	Controllers Controllers `json:"controllers,omitempty"`
+}
+
+// Vault is the configuration of the default HashiCorp Vault backend.
+type Vault struct {
+	// URL of the Vault server or a comma separated list of Vault cluster node URLs (reload).
+	URL string `json:"url,omitempty"`
+	// CAFile is the path of the Vault server CA (reload).
+	CAFile string `json:"caFile,omitempty"`
+	// TLSInsecure disables TLS checks (reload).
+	TLSInsecure bool `json:"tlsInsecure,omitempty"`
+	// AuthPath is the path of the Vault kubeauth credential backend mount (reload).
+	AuthPath string `json:"authPath,omitempty"`
+	// Role is the template that results in a role name (reload).
+	Role string `json:"role,omitempty"`
+	// SecretPath is the template that results in a Vault path (reload).
+	SecretPath string `json:"secretPath,omitempty"`
+}
+
+// Impersonation is the configuration of ServiceAccount impersonation.
+type Impersonation struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Audiences of impersonated ServiceAccount tokens, defaults to the API server audience.
+	Audiences []string `json:"audiences,omitempty"`
+}
+
+// LeaderElection is the configuration of leader election.
+type LeaderElection struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// ID is the name of the ConfigMap that holds the leader lock.
+	ID string `json:"id,omitempty"`
+	// Namespace of the ConfigMap, defaults to the namespace vault-secret runs in.
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// Shutdown is the configuration of the shutdown of a replica.
+type Shutdown struct {
+	// Delay is the time between receiving SIGTERM and shutting down, during this time admission requests are still
+	// served so the API server can stop sending requests to the replica.
+	Delay metav1.Duration `json:"delay,omitempty"`
+	// Timeout is the maximum time to wait for in-flight admission requests to complete.
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+}
+
+// Webhook is the configuration of the webhook endpoint.
+type Webhook struct {
+	// ServiceName is the name of the webhook Service.
+	ServiceName string `json:"serviceName,omitempty"`
+	// Namespace of the Service and of the certificate Secret, defaults to the namespace vault-secret runs in.
+	Namespace string `json:"namespace,omitempty"`
+	// ConfigurationName is the name of the MutatingWebhookConfiguration.
+	ConfigurationName string `json:"configurationName,omitempty"`
+	// Certs are self-managed webhook serving certificates.
+	Certs WebhookCerts `json:"certs,omitempty"`
+	// Registration of the MutatingWebhookConfiguration on startup.
+	Registration WebhookRegistration `json:"registration,omitempty"`
+}
+
+// WebhookCerts is the configuration of self-managed webhook certificates.
+// When enabled a self-signed CA and serving certificate are generated instead of using the certificate in
+// --webhook-cert-dir provided by for example cert-manager.
+type WebhookCerts struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// SecretName is the Secret that stores the CA and serving certificate.
+	SecretName string `json:"secretName,omitempty"`
+	// Validity of the serving certificate, defaults to 8760h (1 year).
+	Validity metav1.Duration `json:"validity,omitempty"`
+	// RotateBefore is the time before expiry a certificate is replaced, defaults to 720h (30 days).
+	RotateBefore metav1.Duration `json:"rotateBefore,omitempty"`
+}
+
+// WebhookRegistration is the configuration of the MutatingWebhookConfiguration that vault-secret creates or updates
+// on startup.
+// When registration is enabled at startup changes of the other fields are applied without restart (reload).
+type WebhookRegistration struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// ObjectSelector selects the Secrets that are sent to the webhook, defaults to the vault.mmlt.nl/inject=true label.
+	// An empty selector selects all Secrets.
+	ObjectSelector *metav1.LabelSelector `json:"objectSelector,omitempty"`
+	// ExcludeNamespaces are namespaces whose Secrets are never sent to the webhook, defaults to kube-system.
+	// The namespace vault-secret runs in is always excluded.
+	ExcludeNamespaces []string `json:"excludeNamespaces,omitempty"`
+	// Timeout of a webhook call (1s-30s), defaults to 10s.
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+	// ReinvocationPolicy is Never or IfNeeded, defaults to Never.
+	ReinvocationPolicy string `json:"reinvocationPolicy,omitempty"`
+	// FailurePolicy is Fail or Ignore, defaults to Fail.
+	FailurePolicy string `json:"failurePolicy,omitempty"`
+}
+
+// Controllers selects the controllers to run.
+type Controllers struct {
+	// VaultSecret generates Secrets from VaultSecret resources.
+	VaultSecret bool `json:"vaultSecret,omitempty"`
+	// Push writes values of Secrets annotated with vault.mmlt.nl/push-path to Vault.
+	Push bool `json:"push,omitempty"`
+}
+
+// Default returns a config with default values.
+func Default() *Config {
+	return &Config{
+		Version: Version,
+		Vault: Vault{
+			URL:        "https://vault.example.com",
+			AuthPath:   "kubernetes",
+			Role:       "vaultsecret-{ns}",
+			SecretPath: "{p}",
+		},
+		LeaderElection: LeaderElection{
+			ID: "c87fed36.mmlt.nl",
+		},
+		Shutdown: Shutdown{
+			Delay:   metav1.Duration{Duration: 5 * time.Second},
+			Timeout: metav1.Duration{Duration: 20 * time.Second},
+		},
+		Webhook: Webhook{
+			ServiceName:       "vault-secret-webhook-service",
+			ConfigurationName: "vault-secret-mutating-webhook-configuration",
+			Certs: WebhookCerts{
+				SecretName:   "vault-secret-webhook-cert",
+				Validity:     metav1.Duration{Duration: 365 * 24 * time.Hour},
+				RotateBefore: metav1.Duration{Duration: 30 * 24 * time.Hour},
+			},
+			Registration: WebhookRegistration{
+				ExcludeNamespaces: []string{"kube-system"},
+				Timeout:           metav1.Duration{Duration: 10 * time.Second},
+			},
+		},
+	}
+}
+
+// Load reads file (when not empty) on top of the default config, calls override (when not nil) and validates the result.
+func Load(file string, override func(*Config)) (*Config, error) {
+	var b []byte
+	if file != "" {
+		var err error
+		b, err = ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return Parse(b, override)
+}
+
+// Parse parses b on top of the default config, calls override (when not nil) and validates the result.
+func Parse(b []byte, override func(*Config)) (*Config, error) {
+	c := Default()
+	err := yaml.UnmarshalStrict(b, c)
+	if err != nil {
+		return nil, err
+	}
+	if override != nil {
+		override(c)
+	}
+	err = c.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate returns an error when the config is not valid.
+func (c *Config) Validate() error {
+	if c.Version != Version {
+		return fmt.Errorf("version: expected %s, got %q", Version, c.Version)
+	}
+
+	if c.Vault.URL == "" {
+		return fmt.Errorf("vault.url is required")
+	}
+	if _, err := mutator.ParseTemplate(c.Vault.Role); err != nil {
+		return fmt.Errorf("vault.role: %w", err)
+	}
+	if _, err := mutator.ParseTemplate(c.Vault.SecretPath); err != nil {
+		return fmt.Errorf("vault.secretPath: %w", err)
+	}
+
+	types := vault.Types()
+	names := map[string]bool{}
+	for i, b := range c.Backends {
+		if b.Name == "" {
+			return fmt.Errorf("backends[%d]: name is required", i)
+		}
+		if names[b.Name] {
+			return fmt.Errorf("backends[%d]: duplicate name %s", i, b.Name)
+		}
+		names[b.Name] = true
+		if !contains(types, b.Type) {
+			return fmt.Errorf("backends[%d]: unknown type %q, expected one of %s", i, b.Type, strings.Join(types, ", "))
+		}
+		if b.SecretPath != "" {
+			if _, err := mutator.ParseTemplate(b.SecretPath); err != nil {
+				return fmt.Errorf("backends[%d]: secretPath: %w", i, err)
+			}
+		}
+	}
+
+	if c.LeaderElection.Enabled && c.LeaderElection.ID == "" {
+		return fmt.Errorf("leaderElection.id is required")
+	}
+
+	if err := c.Webhook.validate(); err != nil {
+		return err
+	}
+
+	if c.AuthorizeRequester != "" {
+		if _, _, err := c.RequesterAuthorization(); err != nil {
+			return err
+		}
+		// the controllers read and write Vault on behalf of the creator of a resource without authorizing it.
+		if c.Controllers.VaultSecret || c.Controllers.Push {
+			return fmt.Errorf("authorizeRequester can't be combined with controllers.vaultSecret or controllers.push")
+		}
+	}
+
+	return nil
+}
+
+// Validate returns an error when the webhook config is not valid.
+func (w *Webhook) validate() error {
+	if (w.Certs.Enabled || w.Registration.Enabled) && (w.ServiceName == "" || w.ConfigurationName == "") {
+		return fmt.Errorf("webhook.serviceName and webhook.configurationName are required")
+	}
+
+	if w.Certs.Enabled {
+		if w.Certs.SecretName == "" {
+			return fmt.Errorf("webhook.certs.secretName is required")
+		}
+		if w.Certs.RotateBefore.Duration >= w.Certs.Validity.Duration {
+			return fmt.Errorf("webhook.certs.rotateBefore must be less than webhook.certs.validity")
+		}
+	}
+
+	r := w.Registration
+	if r.Timeout.Duration != 0 && (r.Timeout.Duration < time.Second || r.Timeout.Duration > 30*time.Second) {
+		return fmt.Errorf("webhook.registration.timeout: expected 1s-30s, got %s", r.Timeout.Duration)
+	}
+	if !contains([]string{"", "Never", "IfNeeded"}, r.ReinvocationPolicy) {
+		return fmt.Errorf("webhook.registration.reinvocationPolicy: expected Never or IfNeeded, got %q", r.ReinvocationPolicy)
+	}
+	if !contains([]string{"", "Fail", "Ignore"}, r.FailurePolicy) {
+		return fmt.Errorf("webhook.registration.failurePolicy: expected Fail or Ignore, got %q", r.FailurePolicy)
+	}
+	if r.ObjectSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(r.ObjectSelector); err != nil {
+			return fmt.Errorf("webhook.registration.objectSelector: %w", err)
+		}
+	}
+	return nil
+}
+
+// RequesterAuthorization returns the verb and group/resource of AuthorizeRequester.
+func (c *Config) RequesterAuthorization() (string, schema.GroupResource, error) {
+	vr := strings.SplitN(c.AuthorizeRequester, ":", 2)
+	if len(vr) != 2 || vr[0] == "" || vr[1] == "" {
+		return "", schema.GroupResource{}, fmt.Errorf("authorizeRequester: expected verb:resource.group, got %q", c.AuthorizeRequester)
+	}
+	return vr[0], schema.ParseGroupResource(vr[1]), nil
+}
+
+// RestartRequired returns true when c differs from old in fields that are not reloaded.
+func (c *Config) RestartRequired(old *Config) bool {
+	return !reflect.DeepEqual(c.static(), old.static())
+}
+
+// Static returns a copy of c without the fields that are reloaded.
+func (c *Config) static() Config {
+	s := *c
+	s.Vault = Vault{}
+	s.ClusterName = ""
+	s.Backends = nil
+	s.Cache = cache.Config{}
+	s.CircuitBreaker = breaker.Config{}
+	s.Policies = false
+	s.Webhook.Registration = WebhookRegistration{Enabled: c.Webhook.Registration.Enabled}
+	return s
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
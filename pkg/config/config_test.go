@@ -0,0 +1,248 @@
+package config
+
+import (
+	"fmt"
+	"github.com/mmlt/testr"
+	"github.com/mmlt/vault-secret/pkg/vault"
+	_ "github.com/mmlt/vault-secret/pkg/vault/file"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		it       string
+		in       string
+		override func(*Config)
+		want     func(*Config)
+		wantErr  string
+	}{
+		{
+			it:   "should_default_empty_file",
+			in:   "",
+			want: func(c *Config) {},
+		},
+		{
+			it: "should_read_values",
+			in: `
+version: v1
+vault:
+  url: https://vault.local
+  role: "vs-{nslabel:team}"
+clusterName: prod
+backends:
+- name: files
+  type: file
+  options:
+    dir: /etc/secrets
+controllers:
+  push: true
+`,
+			want: func(c *Config) {
+				c.Vault.URL = "https://vault.local"
+				c.Vault.Role = "vs-{nslabel:team}"
+				c.ClusterName = "prod"
+				c.Backends = []vault.BackendConfig{{Name: "files", Type: "file", Options: map[string]string{"dir": "/etc/secrets"}}}
+				c.Controllers.Push = true
+			},
+		},
+		{
+			it: "should_read_cache_durations",
+			in: `
+cache:
+  enabled: true
+  ttl: 1m
+  mountTTLs:
+    static/: 1h
+`,
+			want: func(c *Config) {
+				c.Cache.Enabled = true
+				c.Cache.TTL = metav1.Duration{Duration: time.Minute}
+				c.Cache.MountTTLs = map[string]metav1.Duration{"static/": {Duration: time.Hour}}
+			},
+		},
+		{
+			it: "should_read_backends_config_file",
+			in: `
+backends:
+- name: files
+  type: file
+`,
+			want: func(c *Config) {
+				c.Backends = []vault.BackendConfig{{Name: "files", Type: "file"}}
+			},
+		},
+		{
+			it: "should_let_override_win",
+			in: `
+vault:
+  url: https://vault.local
+`,
+			override: func(c *Config) { c.Vault.URL = "https://flag.local" },
+			want:     func(c *Config) { c.Vault.URL = "https://flag.local" },
+		},
+		{
+			it:      "should_error_on_unknown_field",
+			in:      "vault:\n  address: x\n",
+			wantErr: `error unmarshaling JSON: while decoding JSON: json: unknown field "address"`,
+		},
+		{
+			it:      "should_error_on_unknown_version",
+			in:      "version: v2\n",
+			wantErr: `version: expected v1, got "v2"`,
+		},
+		{
+			it:      "should_error_on_invalid_template",
+			in:      "vault:\n  secretPath: \"{x}\"\n",
+			wantErr: `vault.secretPath: template "{x}": unknown variable {x}`,
+		},
+		{
+			it:      "should_error_on_duplicate_backend",
+			in:      "backends:\n- name: a\n  type: file\n- name: a\n  type: file\n",
+			wantErr: "backends[1]: duplicate name a",
+		},
+		{
+			it:      "should_error_on_unknown_backend_type",
+			in:      "backends:\n- name: a\n  type: x\n",
+			wantErr: fmt.Sprintf(`backends[0]: unknown type "x", expected one of %s`, "file"),
+		},
+		{
+			it: "should_read_webhook_registration",
+			in: `
+webhook:
+  registration:
+    enabled: true
+    objectSelector: {}
+    timeout: 5s
+    reinvocationPolicy: IfNeeded
+`,
+			want: func(c *Config) {
+				c.Webhook.Registration.Enabled = true
+				c.Webhook.Registration.ObjectSelector = &metav1.LabelSelector{}
+				c.Webhook.Registration.Timeout = metav1.Duration{Duration: 5 * time.Second}
+				c.Webhook.Registration.ReinvocationPolicy = "IfNeeded"
+			},
+		},
+		{
+			it:      "should_error_on_rotation_longer_than_validity",
+			in:      "webhook:\n  certs:\n    enabled: true\n    validity: 24h\n    rotateBefore: 48h\n",
+			wantErr: "webhook.certs.rotateBefore must be less than webhook.certs.validity",
+		},
+		{
+			it:      "should_error_on_invalid_reinvocationPolicy",
+			in:      "webhook:\n  registration:\n    reinvocationPolicy: Always\n",
+			wantErr: `webhook.registration.reinvocationPolicy: expected Never or IfNeeded, got "Always"`,
+		},
+		{
+			it:      "should_error_on_webhook_timeout_out_of_range",
+			in:      "webhook:\n  registration:\n    timeout: 1m\n",
+			wantErr: "webhook.registration.timeout: expected 1s-30s, got 1m0s",
+		},
+		{
+			it:      "should_error_on_invalid_authorizeRequester",
+			in:      "authorizeRequester: inject\n",
+			wantErr: `authorizeRequester: expected verb:resource.group, got "inject"`,
+		},
+		{
+			it:      "should_error_on_authorizeRequester_with_controllers",
+			in:      "authorizeRequester: inject:vaultpaths.vault.mmlt.nl\ncontrollers:\n  push: true\n",
+			wantErr: "authorizeRequester can't be combined with controllers.vaultSecret or controllers.push",
+		},
+	}
+	for _, tst := range tests {
+		t.Run(tst.it, func(t *testing.T) {
+			got, err := Parse([]byte(tst.in), tst.override)
+			if tst.wantErr != "" {
+				assert.EqualError(t, err, tst.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			want := Default()
+			tst.want(want)
+			assert.Equal(t, want, got)
+		})
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	old := Default()
+
+	c := Default()
+	c.Vault.URL = "https://other.local"
+	c.Backends = []vault.BackendConfig{{Name: "a", Type: "file"}}
+	assert.False(t, c.RestartRequired(old))
+
+	c.Vault.Role = "other-{ns}"
+	c.Vault.SecretPath = "other/{ns}/{p}"
+	c.ClusterName = "other"
+	c.Policies = true
+	c.Webhook.Registration.FailurePolicy = "Ignore"
+	assert.False(t, c.RestartRequired(old))
+
+	c.Webhook.Registration.Enabled = !old.Webhook.Registration.Enabled
+	assert.True(t, c.RestartRequired(old))
+
+	c = Default()
+	c.Shutdown.Delay.Duration++
+	assert.True(t, c.RestartRequired(old))
+}
+
+func TestWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "config.yaml")
+	write := func(s string) {
+		err := ioutil.WriteFile(file, []byte(s), 0644)
+		assert.NoError(t, err)
+	}
+
+	write("vault:\n  url: https://one.local\n")
+
+	changes := make(chan *Config, 10)
+	w := &Watcher{
+		File: file,
+		OnChange: func(c *Config) error {
+			changes <- c
+			return nil
+		},
+		Log: testr.New(t),
+	}
+	c, err := w.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "https://one.local", c.Vault.URL)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		_ = w.Start(stop)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	t.Run("should_reload_changed_file", func(t *testing.T) {
+		write("vault:\n  url: https://two.local\n")
+		select {
+		case c := <-changes:
+			assert.Equal(t, "https://two.local", c.Vault.URL)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no reload")
+		}
+	})
+
+	t.Run("should_keep_last_good_config_on_error", func(t *testing.T) {
+		write("vault:\n  url: \"\"\n")
+		select {
+		case c := <-changes:
+			t.Fatalf("unexpected reload: %v", c)
+		case <-time.After(time.Second):
+		}
+		assert.Equal(t, float64(0), testutil.ToFloat64(lastReloadSuccessful))
+	})
+}
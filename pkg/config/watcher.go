@@ -0,0 +1,128 @@
+package config
+
+import (
+	"bytes"
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	reloadsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "vaultsecret_config_reloads_total",
+		Help: "Total number of configuration file reloads by result (success or error).",
+	}, []string{"result"})
+	lastReloadSuccessful = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "vaultsecret_config_last_reload_successful",
+		Help: "Whether the last configuration file reload was successful (1) or the last good config is in use (0).",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(reloadsTotal, lastReloadSuccessful)
+	lastReloadSuccessful.Set(1)
+}
+
+// Watcher reloads a config file when it changes.
+// Watcher implements manager.Runnable.
+type Watcher struct {
+	// File is the config file to watch.
+	File string
+	// Override is called with each loaded config before it's validated, typically to apply command line flags.
+	Override func(*Config)
+	// OnChange is called with a changed config, when it returns an error the last good config stays in use.
+	OnChange func(*Config) error
+
+	Log logr.Logger
+
+	// last is the content of the file that is in use.
+	last []byte
+}
+
+// Load reads the file for the first time.
+func (w *Watcher) Load() (*Config, error) {
+	b, err := ioutil.ReadFile(w.File)
+	if err != nil {
+		return nil, err
+	}
+	c, err := Parse(b, w.Override)
+	if err != nil {
+		return nil, err
+	}
+	w.last = b
+	return c, nil
+}
+
+// Start watches the file until stop is closed.
+// The directory of the file is watched so updates of mounted ConfigMaps (that replace a symlink) are seen.
+func (w *Watcher) Start(stop <-chan struct{}) error {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer fw.Close()
+
+	err = fw.Add(filepath.Dir(w.File))
+	if err != nil {
+		return err
+	}
+
+	// changes often come in bursts, wait for a quiet period before reloading.
+	var delay <-chan time.Time
+	for {
+		select {
+		case <-stop:
+			return nil
+		case _, ok := <-fw.Events:
+			if !ok {
+				return nil
+			}
+			delay = time.After(200 * time.Millisecond)
+		case err, ok := <-fw.Errors:
+			if !ok {
+				return nil
+			}
+			w.Log.Error(err, "watch config")
+		case <-delay:
+			delay = nil
+			w.reload()
+		}
+	}
+}
+
+// NeedLeaderElection returns false because all replicas need to reload the config.
+func (w *Watcher) NeedLeaderElection() bool {
+	return false
+}
+
+// Reload loads the file and calls OnChange when the content has changed.
+func (w *Watcher) reload() {
+	b, err := ioutil.ReadFile(w.File)
+	if err == nil && bytes.Equal(b, w.last) {
+		return
+	}
+
+	var c *Config
+	if err == nil {
+		c, err = Parse(b, w.Override)
+	}
+	if err == nil {
+		err = w.OnChange(c)
+	}
+	if err != nil {
+		w.Log.Error(err, "reload config, keeping last good config", "file", w.File)
+		reloadsTotal.WithLabelValues("error").Inc()
+		lastReloadSuccessful.Set(0)
+		return
+	}
+
+	w.last = b
+	w.Log.Info("reloaded config", "file", w.File)
+	reloadsTotal.WithLabelValues("success").Inc()
+	lastReloadSuccessful.Set(1)
+}
@@ -0,0 +1,42 @@
+// Package health provides the readiness checks of the manager.
+package health
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/mmlt/vault-secret/pkg/vault"
+	"net/http"
+	"path/filepath"
+	"sync/atomic"
+
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+)
+
+// Certs returns a check that fails until the webhook serving certificate and key in dir can be loaded.
+func Certs(dir string) healthz.Checker {
+	return func(_ *http.Request) error {
+		_, err := tls.LoadX509KeyPair(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"))
+		if err != nil {
+			return fmt.Errorf("webhook certificate: %w", err)
+		}
+		return nil
+	}
+}
+
+// Backend returns a check that fails until the backend with name has reported to be healthy once.
+// After that the check keeps succeeding; an outage of the backend is handled by failover, the circuit breaker and the
+// cache and shouldn't take all replicas out of service at the same time.
+func Backend(backends *vault.Backends, name string) healthz.Checker {
+	var healthy int32
+	return func(_ *http.Request) error {
+		if atomic.LoadInt32(&healthy) == 1 {
+			return nil
+		}
+		err := backends.Health(name)
+		if err != nil {
+			return err
+		}
+		atomic.StoreInt32(&healthy, 1)
+		return nil
+	}
+}
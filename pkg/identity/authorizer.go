@@ -0,0 +1,54 @@
+package identity
+
+import (
+	"context"
+	"fmt"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// Authorizer checks with RBAC if a requester is allowed to access a Vault path.
+// The check is a SubjectAccessReview for Verb on Group/Resource with the path as resource name, for example
+// verb "inject" on "vaultpaths.vault.mmlt.nl" named "secret/data/ns/default/example".
+type Authorizer struct {
+	Client kubernetes.Interface
+
+	Verb     string
+	Group    string
+	Resource string
+}
+
+// NewAuthorizer returns an Authorizer that accesses the API server with cfg.
+func NewAuthorizer(cfg *rest.Config, verb, group, resource string) (*Authorizer, error) {
+	c, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Authorizer{
+		Client:   c,
+		Verb:     verb,
+		Group:    group,
+		Resource: resource,
+	}, nil
+}
+
+// Authorize returns an ErrForbidden error when user isn't allowed to access path from namespace.
+func (a *Authorizer) Authorize(ctx context.Context, user authenticationv1.UserInfo, namespace, path string) error {
+	ok, err := review(ctx, a.Client, user, &authorizationv1.ResourceAttributes{
+		Namespace: namespace,
+		Verb:      a.Verb,
+		Group:     a.Group,
+		Resource:  a.Resource,
+		Name:      path,
+	})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: user %s can't %s %s.%s %s in namespace %s", ErrForbidden, user.Username, a.Verb, a.Resource, a.Group, path, namespace)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+// Package identity provides ServiceAccount tokens to login to Vault on behalf of the requester.
+package identity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// +kubebuilder:rbac:groups="",resources=serviceaccounts/token,verbs=create
+// +kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+// ErrForbidden is returned when the requester isn't allowed to use a ServiceAccount.
+var ErrForbidden = errors.New("forbidden")
+
+// Impersonator mints tokens of ServiceAccounts that a requester is allowed to use.
+type Impersonator struct {
+	Client kubernetes.Interface
+
+	// Audiences of the minted tokens, when empty the API server audience is used.
+	Audiences []string
+	// ExpirationSeconds is the lifetime of the minted tokens.
+	ExpirationSeconds int64
+}
+
+// New returns an Impersonator that accesses the API server with cfg.
+func New(cfg *rest.Config, audiences []string) (*Impersonator, error) {
+	c, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Impersonator{
+		Client:            c,
+		Audiences:         audiences,
+		ExpirationSeconds: 600,
+	}, nil
+}
+
+// Token returns a token of ServiceAccount namespace/name.
+// The user must be allowed to create tokens for the ServiceAccount, otherwise an ErrForbidden error is returned.
+func (im *Impersonator) Token(ctx context.Context, user authenticationv1.UserInfo, namespace, name string) (string, error) {
+	ok, err := review(ctx, im.Client, user, &authorizationv1.ResourceAttributes{
+		Namespace:   namespace,
+		Verb:        "create",
+		Resource:    "serviceaccounts",
+		Subresource: "token",
+		Name:        name,
+	})
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("%w: user %s can't create tokens for serviceaccount %s/%s", ErrForbidden, user.Username, namespace, name)
+	}
+
+	exp := im.ExpirationSeconds
+	tr, err := im.Client.CoreV1().ServiceAccounts(namespace).CreateToken(name, &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{
+			Audiences:         im.Audiences,
+			ExpirationSeconds: &exp,
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("tokenrequest %s/%s: %w", namespace, name, err)
+	}
+
+	return tr.Status.Token, nil
+}
+
+// Review returns true when user is allowed to access the resource described by attrs.
+func review(ctx context.Context, client kubernetes.Interface, user authenticationv1.UserInfo, attrs *authorizationv1.ResourceAttributes) (bool, error) {
+	sar := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			ResourceAttributes: attrs,
+			User:               user.Username,
+			Groups:             user.Groups,
+			UID:                user.UID,
+			Extra:              extra(user.Extra),
+		},
+	}
+	sar, err := client.AuthorizationV1().SubjectAccessReviews().CreateContext(ctx, sar)
+	if err != nil {
+		return false, fmt.Errorf("subjectaccessreview: %w", err)
+	}
+	return sar.Status.Allowed, nil
+}
+
+// Extra converts authentication extra values to authorization extra values.
+func extra(in map[string]authenticationv1.ExtraValue) map[string]authorizationv1.ExtraValue {
+	if in == nil {
+		return nil
+	}
+	r := make(map[string]authorizationv1.ExtraValue, len(in))
+	for k, v := range in {
+		r[k] = authorizationv1.ExtraValue(v)
+	}
+	return r
+}
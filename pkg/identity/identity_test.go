@@ -0,0 +1,80 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestToken(t *testing.T) {
+	c := fake.NewSimpleClientset()
+	// alice can create tokens for serviceaccount default/app
+	c.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		sar := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		ra := sar.Spec.ResourceAttributes
+		sar.Status.Allowed = sar.Spec.User == "alice" &&
+			ra.Verb == "create" && ra.Resource == "serviceaccounts" && ra.Subresource == "token" &&
+			ra.Namespace == "default" && ra.Name == "app"
+		return true, sar, nil
+	})
+	c.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		tr := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenRequest)
+		tr.Status.Token = "token-of-" + action.GetNamespace()
+		return true, tr, nil
+	})
+
+	im := &Impersonator{Client: c, ExpirationSeconds: 600}
+
+	t.Run("should_mint_token_when_allowed", func(t *testing.T) {
+		got, err := im.Token(context.Background(), authenticationv1.UserInfo{Username: "alice"}, "default", "app")
+		assert.NoError(t, err)
+		assert.Equal(t, "token-of-default", got)
+	})
+
+	t.Run("should_deny_other_user", func(t *testing.T) {
+		_, err := im.Token(context.Background(), authenticationv1.UserInfo{Username: "bob"}, "default", "app")
+		assert.True(t, errors.Is(err, ErrForbidden))
+	})
+
+	t.Run("should_deny_other_serviceaccount", func(t *testing.T) {
+		_, err := im.Token(context.Background(), authenticationv1.UserInfo{Username: "alice"}, "default", "admin")
+		assert.True(t, errors.Is(err, ErrForbidden))
+	})
+}
+
+func TestAuthorize(t *testing.T) {
+	c := fake.NewSimpleClientset()
+	// alice can inject secret/data/ns/default/example in namespace default
+	c.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		sar := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		ra := sar.Spec.ResourceAttributes
+		sar.Status.Allowed = sar.Spec.User == "alice" &&
+			ra.Verb == "inject" && ra.Group == "vault.mmlt.nl" && ra.Resource == "vaultpaths" &&
+			ra.Namespace == "default" && ra.Name == "secret/data/ns/default/example"
+		return true, sar, nil
+	})
+
+	a := &Authorizer{Client: c, Verb: "inject", Group: "vault.mmlt.nl", Resource: "vaultpaths"}
+
+	t.Run("should_allow_permitted_path", func(t *testing.T) {
+		err := a.Authorize(context.Background(), authenticationv1.UserInfo{Username: "alice"}, "default", "secret/data/ns/default/example")
+		assert.NoError(t, err)
+	})
+
+	t.Run("should_deny_other_path", func(t *testing.T) {
+		err := a.Authorize(context.Background(), authenticationv1.UserInfo{Username: "alice"}, "default", "secret/data/ns/other/example")
+		assert.True(t, errors.Is(err, ErrForbidden))
+	})
+
+	t.Run("should_deny_other_user", func(t *testing.T) {
+		err := a.Authorize(context.Background(), authenticationv1.UserInfo{Username: "bob"}, "default", "secret/data/ns/default/example")
+		assert.True(t, errors.Is(err, ErrForbidden))
+	})
+}
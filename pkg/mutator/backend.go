@@ -0,0 +1,29 @@
+package mutator
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// BackendLabel is a Namespace label that selects the backend for the Secrets and VaultSecrets in the namespace that
+// don't select a backend themselves.
+// For example a Vault per region can be selected with "vault.mmlt.nl/backend: vault-eu".
+const BackendLabel = "vault.mmlt.nl/backend"
+
+// BackendName returns name when it's not empty, otherwise it returns the BackendLabel value of namespace.
+// An empty result selects the default backend.
+func (e *TemplateEnv) BackendName(ctx context.Context, name, namespace string) (string, error) {
+	if name != "" || e.Client == nil {
+		return name, nil
+	}
+
+	ns := &corev1.Namespace{}
+	err := e.Client.Get(ctx, types.NamespacedName{Name: namespace}, ns)
+	if err != nil {
+		return "", fmt.Errorf("get namespace: %w", err)
+	}
+	return ns.Labels[BackendLabel], nil
+}
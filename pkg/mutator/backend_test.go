@@ -0,0 +1,159 @@
+package mutator
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/mmlt/vault-secret/pkg/vault"
+	"github.com/mmlt/vault-secret/pkg/vault/kubernetes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestBackendName(t *testing.T) {
+	env := &TemplateEnv{
+		Client: fake.NewFakeClient(
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name:   "eu",
+				Labels: map[string]string{BackendLabel: "vault-eu"},
+			}},
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name: "plain",
+			}},
+		),
+	}
+
+	tests := []struct {
+		it        string
+		name      string
+		namespace string
+		want      string
+		wantErr   bool
+	}{
+		{it: "should_prefer_name", name: "vault-global", namespace: "eu", want: "vault-global"},
+		{it: "should_use_namespace_label", namespace: "eu", want: "vault-eu"},
+		{it: "should_default_without_label", namespace: "plain", want: ""},
+		{it: "should_error_on_missing_namespace", namespace: "missing", wantErr: true},
+	}
+	for _, tst := range tests {
+		t.Run(tst.it, func(t *testing.T) {
+			got, err := env.BackendName(context.Background(), tst.name, tst.namespace)
+			if tst.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tst.want, got)
+		})
+	}
+
+	t.Run("should_not_lookup_without_client", func(t *testing.T) {
+		got, err := (&TemplateEnv{}).BackendName(context.Background(), "", "eu")
+		assert.NoError(t, err)
+		assert.Equal(t, "", got)
+	})
+}
+
+func TestBackendSecretPath(t *testing.T) {
+	clnt := fake.NewFakeClient(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:        "shared",
+			Annotations: map[string]string{kubernetes.AllowedNamespacesAnnotation: "default"},
+		}},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shared", Name: "registry"},
+			Data:       map[string][]byte{"token": []byte("s3cr3t")},
+		},
+	)
+	kubernetes.Register(clnt)
+
+	backends := vault.NewBackends(&fakeBackend{})
+	err := backends.Replace(&fakeBackend{}, []vault.BackendConfig{
+		{Name: "k8s", Type: "kubernetes"},
+		{Name: "k8s-prefixed", Type: "kubernetes", SecretPath: "shared/{p}"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &SecretMutator{
+		Backends: backends,
+		Settings: NewSettings(Templates{
+			VaultAuthPath:   "kubernetes",
+			VaultRole:       "vaultsecret-{ns}",
+			VaultSecretPath: "secret/{ns}/{p}",
+			TemplateEnv:     TemplateEnv{Client: clnt},
+		}),
+		Log: logf.Log,
+	}
+	d, err := admission.NewDecoder(runtime.NewScheme())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, m.InjectDecoder(d))
+
+	tests := []struct {
+		it      string
+		backend string
+		path    string
+		want    string
+	}{
+		{
+			it:   "should_apply_global_template_to_default_backend",
+			path: "app",
+			want: "vaultsecret-default:secret/default/app",
+		},
+		{
+			it:      "should_not_apply_global_template_to_other_backend_types",
+			backend: "k8s",
+			path:    "shared/registry",
+			want:    "s3cr3t",
+		},
+		{
+			it:      "should_apply_backend_template",
+			backend: "k8s-prefixed",
+			path:    "registry",
+			want:    "s3cr3t",
+		},
+	}
+	for _, tst := range tests {
+		t.Run(tst.it, func(t *testing.T) {
+			raw, err := json.Marshal(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				Name:      "app",
+				Annotations: map[string]string{
+					"vault.mmlt.nl/inject":         "true",
+					"vault.mmlt.nl/inject-backend": tst.backend,
+					"vault.mmlt.nl/inject-path":    tst.path,
+					"vault.mmlt.nl/inject-fields":  "v=v,token=token",
+				},
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp := m.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1beta1.AdmissionRequest{
+				Namespace: "default",
+				Object:    runtime.RawExtension{Raw: raw},
+			}})
+			if !assert.True(t, resp.Allowed, "%v", resp.Result) || !assert.Len(t, resp.Patches, 1) {
+				return
+			}
+			v, _ := resp.Patches[0].Value.(map[string]interface{})
+			var got []string
+			for _, s := range v {
+				b, err := base64.StdEncoding.DecodeString(s.(string))
+				assert.NoError(t, err)
+				got = append(got, string(b))
+			}
+			assert.Equal(t, []string{tst.want}, got)
+		})
+	}
+}
@@ -3,10 +3,15 @@ package mutator
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/go-logr/logr"
+	"github.com/mmlt/vault-secret/pkg/identity"
+	"github.com/mmlt/vault-secret/pkg/policy"
 	"github.com/mmlt/vault-secret/pkg/vault"
 	"net/http"
 	"strings"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
@@ -14,23 +19,27 @@ import (
 
 // +kubebuilder:webhook:path=/mutate-v1-secret,mutating=true,failurePolicy=fail,groups="",resources=secrets,verbs=create;update,versions=v1,name=msecret.kb.io
 
+// InjectLabel is a Secret label that enables injection like the vault.mmlt.nl/inject annotation does.
+// Unlike annotations, labels can be matched by the objectSelector of a webhook so only Secrets with this label are sent
+// to the webhook.
+const InjectLabel = "vault.mmlt.nl/inject"
+
 // SecretMutator populates Secret data with value(s) read from Vault.
 type SecretMutator struct {
-	// VaultAuthPath is the mount path of the kubeauth backend (typically "kubernetes")
-	VaultAuthPath string
+	// Settings are the templates and policy that select the role and path to read.
+	Settings *Settings
 
-	// VaultRole is a template that results in a role name.
-	// Arguments: {ns} for namespace, {n} for name.
-	// For example "vaultsecret-{ns}" produces "vaultsecret-default" when the Secret is in namespace "default".
-	VaultRole string
+	// Backends are the vaults to read from.
+	// The vault.mmlt.nl/inject-backend annotation or the BackendLabel of the namespace selects a backend by name,
+	// the default backend has no name.
+	Backends *vault.Backends
 
-	// VaultSecretPath is template that results in a Vault path.
-	// Arguments: {ns} for namespace, {n} for name, {p} for the vault.mmlt.nl/inject-path annotation value.
-	// Example: "secret/{ns}/{p}"
-	VaultSecretPath string
+	// Impersonator provides the tokens of ServiceAccounts selected by the vault.mmlt.nl/service-account annotation.
+	// When nil the annotation is not allowed.
+	Impersonator *identity.Impersonator
 
-	// Vault accessor.
-	Vault vault.Loginer
+	// Authorizer checks if the requester is allowed to read the Vault path, nil allows all.
+	Authorizer *identity.Authorizer
 
 	Log logr.Logger
 
@@ -41,6 +50,11 @@ type SecretMutator struct {
 // Handle a admission request.
 // Read annotations, query Vault, set Secret data.
 func (m *SecretMutator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.DryRun != nil && *req.DryRun {
+		// reading Vault and requesting tokens are side effects, the webhook is registered with sideEffects NoneOnDryRun.
+		return admission.Allowed("")
+	}
+
 	secret := &corev1.Secret{}
 
 	err := m.decoder.Decode(req, secret)
@@ -59,38 +73,178 @@ func (m *SecretMutator) Handle(ctx context.Context, req admission.Request) admis
 	rpath := secret.Annotations["vault.mmlt.nl/inject-path"]
 	// A comma separated list of k8s secret field name = vault secret field name pairs.
 	fields := secret.Annotations["vault.mmlt.nl/inject-fields"]
+	// The name of the backend to read from. Defaults to the backend selected by the namespace or the default backend.
+	backend := secret.Annotations["vault.mmlt.nl/inject-backend"]
+	// The name of the transit key to decrypt ciphertext values with.
+	transitKey := secret.Annotations["vault.mmlt.nl/transit-key"]
+	// The name of a ServiceAccount in the namespace of the Secret to login as.
+	serviceAccount := secret.Annotations["vault.mmlt.nl/service-account"]
+	// The TTL of a response-wrapped secret, when set only the wrapping token is stored in the Secret.
+	wrap := secret.Annotations["vault.mmlt.nl/wrap-ttl"]
+
+	var wrapTTL time.Duration
+	if wrap != "" {
+		wrapTTL, err = time.ParseDuration(wrap)
+		if err != nil || wrapTTL < time.Second {
+			err = fmt.Errorf("vault.mmlt.nl/wrap-ttl: expected a duration of at least 1s, got %q", wrap)
+			m.Log.Error(err, "mutate/wrap")
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	}
+
+	if transitKey != "" {
+		// the key is part of a Vault path
+		if err := ValidatePath(transitKey); err != nil {
+			err = fmt.Errorf("vault.mmlt.nl/transit-key: %w", err)
+			m.Log.Error(err, "mutate/decrypt")
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	}
 
-	if enabled != "true" || rpath == "" || fields == "" {
+	if secret.Labels[InjectLabel] == "true" {
+		enabled = "true"
+	}
+
+	inject := enabled == "true" && rpath != "" && (fields != "" || wrapTTL > 0)
+	if !inject && transitKey == "" {
 		// not properly annotated, do not process this secret.
 		return admission.Allowed("")
 	}
 
-	role := replaceNSN(m.VaultRole, secret.Namespace, secret.Name)
-	path := replaceNSNP(m.VaultSecretPath, secret.Namespace, secret.Name, rpath)
+	ts := m.Settings.Get()
 
-	c, err := m.Vault.Login(m.VaultAuthPath, role)
+	backend, err = ts.TemplateEnv.BackendName(ctx, backend, secret.Namespace)
 	if err != nil {
-		m.Log.Error(err, "mutate/login")
+		m.Log.Error(err, "mutate/backend")
 		return admission.Errored(http.StatusInternalServerError, err)
 	}
 
-	_ = ctx // use in Get() when github.com/hashicorp/vault/api.Read() supports context.
-	data, err := c.Get(path)
+	role, err := ts.TemplateEnv.Render(ctx, ts.VaultRole, secret, "")
+	if err != nil {
+		m.Log.Error(err, "mutate/role")
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	var path string
+	if inject {
+		path, err = ts.TemplateEnv.Render(ctx, m.Backends.SecretPath(backend, ts.VaultSecretPath), secret, rpath)
+		if err != nil {
+			m.Log.Error(err, "mutate/path")
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	}
+
+	// paths that are accessed, used to check policies and authorize the requester.
+	var paths []string
+	if inject {
+		paths = append(paths, path)
+	}
+	if transitKey != "" {
+		paths = append(paths, vault.DecryptPath(transitKey))
+	}
+
+	if ts.Policy != nil {
+		for _, p := range paths {
+			reason, err := ts.Policy.Check(ctx, policy.Request{
+				Namespace: secret.Namespace,
+				Backend:   backend,
+				Role:      role,
+				Path:      p,
+			})
+			if err != nil {
+				m.Log.Error(err, "mutate/policy")
+				return admission.Errored(http.StatusInternalServerError, err)
+			}
+			if reason != "" {
+				m.Log.Info("mutate/policy", "secret", secret.Namespace+"/"+secret.Name, "denied", reason)
+				return admission.Denied(reason)
+			}
+		}
+	}
+
+	if m.Authorizer != nil {
+		for _, p := range paths {
+			err = m.Authorizer.Authorize(ctx, req.UserInfo, secret.Namespace, p)
+			if errors.Is(err, identity.ErrForbidden) {
+				m.Log.Info("mutate/authorize", "secret", secret.Namespace+"/"+secret.Name, "denied", err.Error())
+				return admission.Denied(err.Error())
+			}
+			if err != nil {
+				m.Log.Error(err, "mutate/authorize")
+				return admission.Errored(http.StatusInternalServerError, err)
+			}
+		}
+	}
+
+	b, err := m.Backends.Get(backend)
 	if err != nil {
-		m.Log.Error(err, "mutate/get")
+		m.Log.Error(err, "mutate/backend")
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	var jwt string
+	if serviceAccount != "" {
+		if m.Impersonator == nil {
+			err = fmt.Errorf("vault.mmlt.nl/service-account: impersonation is not enabled")
+			m.Log.Error(err, "mutate/impersonate")
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		jwt, err = m.Impersonator.Token(ctx, req.UserInfo, secret.Namespace, serviceAccount)
+		if errors.Is(err, identity.ErrForbidden) {
+			m.Log.Info("mutate/impersonate", "secret", secret.Namespace+"/"+secret.Name, "denied", err.Error())
+			return admission.Denied(err.Error())
+		}
+		if err != nil {
+			m.Log.Error(err, "mutate/impersonate")
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+	}
+
+	c, err := b.Login(vault.LoginRequest{
+		AuthPath:  ts.VaultAuthPath,
+		Role:      role,
+		Namespace: secret.Namespace,
+		JWT:       jwt,
+	})
+	if err != nil {
+		m.Log.Error(err, "mutate/login")
 		return admission.Errored(http.StatusInternalServerError, err)
 	}
 
-	if len(data) > 0 && secret.Data == nil {
-		secret.Data = make(map[string][]byte, len(data))
+	var decrypted int
+	if transitKey != "" {
+		decrypted, err = decrypt(c, transitKey, secret)
+		if err != nil {
+			m.Log.Error(err, "mutate/decrypt")
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
 	}
-	for _, p := range strings.Split(fields, ",") {
-		v := strings.Split(p, "=")
-		if len(v) != 2 {
-			continue
+
+	var data map[string]string
+	if inject && wrapTTL > 0 {
+		token, err := wrapToken(c, path, wrapTTL)
+		if err != nil {
+			m.Log.Error(err, "mutate/wrap")
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		secret.Data[WrappingTokenKey] = []byte(token)
+	} else if inject {
+		_ = ctx // use in Get() when github.com/hashicorp/vault/api.Read() supports context.
+		data, err = c.Get(path)
+		if err != nil {
+			m.Log.Error(err, "mutate/get")
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+
+		if len(data) > 0 && secret.Data == nil {
+			secret.Data = make(map[string][]byte, len(data))
 		}
-		if d, ok := data[v[1]]; ok {
-			secret.Data[v[0]] = []byte(d)
+		for k, f := range ParseFields(fields) {
+			if d, ok := data[f]; ok {
+				secret.Data[k] = []byte(d)
+			}
 		}
 	}
 
@@ -100,26 +254,80 @@ func (m *SecretMutator) Handle(ctx context.Context, req admission.Request) admis
 		return admission.Errored(http.StatusInternalServerError, err)
 	}
 
-	m.Log.Info("mutate", "secret", secret.Namespace+"/"+secret.Name, "role", role, "path", path, "vault", len(data), "secret", len(secret.Data))
+	m.Log.Info("mutate", "secret", secret.Namespace+"/"+secret.Name, "backend", backend, "role", role, "serviceaccount", serviceAccount, "path", path, "vault", len(data), "decrypted", decrypted, "secret", len(secret.Data))
 
 	return admission.PatchResponseFromRaw(req.Object.Raw, js)
 }
 
+// WrappingTokenKey is the Secret data key of the wrapping token when vault.mmlt.nl/wrap-ttl is set.
+const WrappingTokenKey = "wrapping_token"
+
+// WrapToken returns a token that unwraps to the secret at path.
+func wrapToken(c vault.Getter, path string, ttl time.Duration) (string, error) {
+	w, ok := c.(vault.Wrapper)
+	if !ok {
+		return "", fmt.Errorf("backend doesn't support response wrapping")
+	}
+	return w.Wrap(path, ttl)
+}
+
+// CiphertextPrefix is the prefix of Vault transit ciphertext, for example "vault:v1:".
+const ciphertextPrefix = "vault:v"
+
+// Decrypt replaces the transit ciphertext values in secret data and stringData with plaintext.
+// It returns the number of decrypted values.
+func decrypt(c vault.Getter, key string, secret *corev1.Secret) (int, error) {
+	ciphertexts := map[string]string{}
+	for k, v := range secret.Data {
+		if strings.HasPrefix(string(v), ciphertextPrefix) {
+			ciphertexts[k] = string(v)
+		}
+	}
+	for k, v := range secret.StringData {
+		if strings.HasPrefix(v, ciphertextPrefix) {
+			ciphertexts[k] = v
+		}
+	}
+	if len(ciphertexts) == 0 {
+		return 0, nil
+	}
+
+	d, ok := c.(vault.Decrypter)
+	if !ok {
+		return 0, fmt.Errorf("backend doesn't support decryption")
+	}
+
+	plaintexts, err := d.Decrypt(key, ciphertexts)
+	if err != nil {
+		return 0, err
+	}
+
+	for k, v := range plaintexts {
+		if _, ok := secret.StringData[k]; ok {
+			secret.StringData[k] = v
+		} else {
+			secret.Data[k] = []byte(v)
+		}
+	}
+	return len(plaintexts), nil
+}
+
 // InjectDecoder implements the DecoderInjector interface.
 func (m *SecretMutator) InjectDecoder(d *admission.Decoder) error {
 	m.decoder = d
 	return nil
 }
 
-// ReplaceNSNP replaces {ns} with namespace, {n} with name and {p} with path and returns the result.
-// NB. {p} itself may contain {ns}, {n}
-func replaceNSNP(in, namespace, name, path string) string {
-	s := strings.ReplaceAll(in, "{p}", path)
-	return replaceNSN(s, namespace, name)
-}
-
-// ReplaceNSN replaces {ns} with namespace and {n} with name and returns the result.
-func replaceNSN(in, namespace, name string) string {
-	s := strings.ReplaceAll(in, "{ns}", namespace)
-	return strings.ReplaceAll(s, "{n}", name)
+// ParseFields parses a comma separated list of k8s secret field name = vault secret field name pairs.
+// Pairs that are not well formed are skipped.
+func ParseFields(fields string) map[string]string {
+	r := map[string]string{}
+	for _, p := range strings.Split(fields, ",") {
+		v := strings.Split(p, "=")
+		if len(v) != 2 {
+			continue
+		}
+		r[v[0]] = v[1]
+	}
+	return r
 }
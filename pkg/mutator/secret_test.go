@@ -0,0 +1,267 @@
+package mutator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	vaultv1alpha1 "github.com/mmlt/vault-secret/api/v1alpha1"
+	"github.com/mmlt/vault-secret/pkg/identity"
+	"github.com/mmlt/vault-secret/pkg/policy"
+	"github.com/mmlt/vault-secret/pkg/vault"
+	"github.com/stretchr/testify/assert"
+	"testing"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	k8stesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestPolicy(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = vaultv1alpha1.AddToScheme(scheme)
+	clnt := fake.NewFakeClientWithScheme(scheme,
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		&vaultv1alpha1.VaultSecretPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "all"},
+			Spec: vaultv1alpha1.VaultSecretPolicySpec{
+				AllowedPaths: []string{"secret/{ns}/**", "transit/decrypt/{ns}"},
+			},
+		},
+	)
+	m := &SecretMutator{
+		Backends: vault.NewBackends(&fakeBackend{}),
+		Settings: NewSettings(Templates{
+			VaultAuthPath:   "kubernetes",
+			VaultRole:       "vaultsecret-{ns}",
+			VaultSecretPath: "secret/{ns}/{p}",
+			TemplateEnv:     TemplateEnv{Client: clnt},
+			Policy:          &policy.Checker{Client: clnt},
+		}),
+		Log: logf.Log,
+	}
+	d, err := admission.NewDecoder(runtime.NewScheme())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, m.InjectDecoder(d))
+
+	tests := []struct {
+		it          string
+		annotations map[string]string
+		want        string
+	}{
+		{
+			it: "should_allow_path",
+			annotations: map[string]string{
+				"vault.mmlt.nl/inject":        "true",
+				"vault.mmlt.nl/inject-path":   "app",
+				"vault.mmlt.nl/inject-fields": "v=v",
+			},
+		},
+		{
+			it: "should_allow_transit_key",
+			annotations: map[string]string{
+				"vault.mmlt.nl/transit-key": "default",
+			},
+		},
+		{
+			it: "should_deny_transit_key",
+			annotations: map[string]string{
+				"vault.mmlt.nl/transit-key": "other",
+			},
+			want: `denied by VaultSecretPolicy all: path "transit/decrypt/other" is not allowed`,
+		},
+		{
+			it: "should_deny_transit_key_combined_with_allowed_path",
+			annotations: map[string]string{
+				"vault.mmlt.nl/inject":        "true",
+				"vault.mmlt.nl/inject-path":   "app",
+				"vault.mmlt.nl/inject-fields": "v=v",
+				"vault.mmlt.nl/transit-key":   "transit-eu/default",
+			},
+			want: `denied by VaultSecretPolicy all: path "transit-eu/decrypt/default" is not allowed`,
+		},
+	}
+	for _, tst := range tests {
+		t.Run(tst.it, func(t *testing.T) {
+			raw, err := json.Marshal(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "default",
+				Name:        "app",
+				Annotations: tst.annotations,
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp := m.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1beta1.AdmissionRequest{
+				Namespace: "default",
+				Object:    runtime.RawExtension{Raw: raw},
+			}})
+			if tst.want == "" {
+				assert.True(t, resp.Allowed, "%v", resp.Result)
+				return
+			}
+			if assert.False(t, resp.Allowed) {
+				assert.Equal(t, tst.want, string(resp.Result.Reason))
+			}
+		})
+	}
+}
+
+func TestAuthorizer(t *testing.T) {
+	c := k8sfake.NewSimpleClientset()
+	// alice can inject secret/default/app and decrypt with transit key default
+	c.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		sar := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		ra := sar.Spec.ResourceAttributes
+		sar.Status.Allowed = sar.Spec.User == "alice" && ra.Verb == "inject" && ra.Namespace == "default" &&
+			(ra.Name == "secret/default/app" || ra.Name == "transit/decrypt/default")
+		return true, sar, nil
+	})
+
+	clnt := fake.NewFakeClient(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}})
+	m := &SecretMutator{
+		Backends: vault.NewBackends(&fakeBackend{}),
+		Settings: NewSettings(Templates{
+			VaultAuthPath:   "kubernetes",
+			VaultRole:       "vaultsecret-{ns}",
+			VaultSecretPath: "secret/{ns}/{p}",
+			TemplateEnv:     TemplateEnv{Client: clnt},
+		}),
+		Authorizer: &identity.Authorizer{Client: c, Verb: "inject", Group: "vault.mmlt.nl", Resource: "vaultpaths"},
+		Log:        logf.Log,
+	}
+	d, err := admission.NewDecoder(runtime.NewScheme())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, m.InjectDecoder(d))
+
+	tests := []struct {
+		it          string
+		user        string
+		annotations map[string]string
+		want        bool
+	}{
+		{
+			it:   "should_allow_path",
+			user: "alice",
+			annotations: map[string]string{
+				"vault.mmlt.nl/inject":        "true",
+				"vault.mmlt.nl/inject-path":   "app",
+				"vault.mmlt.nl/inject-fields": "v=v",
+			},
+			want: true,
+		},
+		{
+			it:   "should_deny_path_to_other_user",
+			user: "bob",
+			annotations: map[string]string{
+				"vault.mmlt.nl/inject":        "true",
+				"vault.mmlt.nl/inject-path":   "app",
+				"vault.mmlt.nl/inject-fields": "v=v",
+			},
+		},
+		{
+			it:   "should_allow_transit_key",
+			user: "alice",
+			annotations: map[string]string{
+				"vault.mmlt.nl/transit-key": "default",
+			},
+			want: true,
+		},
+		{
+			it:   "should_deny_transit_key",
+			user: "alice",
+			annotations: map[string]string{
+				"vault.mmlt.nl/transit-key": "other",
+			},
+		},
+		{
+			it:   "should_deny_transit_key_to_other_user",
+			user: "bob",
+			annotations: map[string]string{
+				"vault.mmlt.nl/transit-key": "default",
+			},
+		},
+	}
+	for _, tst := range tests {
+		t.Run(tst.it, func(t *testing.T) {
+			raw, err := json.Marshal(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "default",
+				Name:        "app",
+				Annotations: tst.annotations,
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp := m.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1beta1.AdmissionRequest{
+				Namespace: "default",
+				UserInfo:  authenticationv1.UserInfo{Username: tst.user},
+				Object:    runtime.RawExtension{Raw: raw},
+			}})
+			assert.Equal(t, tst.want, resp.Allowed, "%v", resp.Result)
+		})
+	}
+}
+
+// FailingBackend fails the test when it's used.
+type failingBackend struct {
+	t *testing.T
+}
+
+func (b *failingBackend) Login(_ vault.LoginRequest) (vault.Getter, error) {
+	b.t.Error("unexpected login")
+	return nil, errors.New("unexpected login")
+}
+
+func TestDryRun(t *testing.T) {
+	clnt := fake.NewFakeClient(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}})
+	m := &SecretMutator{
+		Backends: vault.NewBackends(&failingBackend{t: t}),
+		Settings: NewSettings(Templates{
+			VaultAuthPath:   "kubernetes",
+			VaultRole:       "vaultsecret-{ns}",
+			VaultSecretPath: "secret/{ns}/{p}",
+			TemplateEnv:     TemplateEnv{Client: clnt},
+		}),
+		Log: logf.Log,
+	}
+	d, err := admission.NewDecoder(runtime.NewScheme())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, m.InjectDecoder(d))
+
+	t.Run("should_allow_dry_run_without_reading_vault", func(t *testing.T) {
+		raw, err := json.Marshal(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "app",
+			Annotations: map[string]string{
+				"vault.mmlt.nl/inject":        "true",
+				"vault.mmlt.nl/inject-path":   "app",
+				"vault.mmlt.nl/inject-fields": "v=v",
+			},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		dryRun := true
+		resp := m.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1beta1.AdmissionRequest{
+			Namespace: "default",
+			DryRun:    &dryRun,
+			Object:    runtime.RawExtension{Raw: raw},
+		}})
+		assert.True(t, resp.Allowed, "%v", resp.Result)
+		assert.Empty(t, resp.Patches)
+	})
+}
@@ -0,0 +1,53 @@
+package mutator
+
+import (
+	"github.com/mmlt/vault-secret/pkg/policy"
+	"sync"
+)
+
+// Templates turn a Secret or VaultSecret into the backend, role and path to read.
+type Templates struct {
+	// VaultAuthPath is the mount path of the kubeauth backend (typically "kubernetes")
+	VaultAuthPath string
+
+	// VaultRole is a template that results in a role name, see Template.
+	// For example "vaultsecret-{ns}" produces "vaultsecret-default" when the Secret is in namespace "default".
+	VaultRole string
+
+	// VaultSecretPath is template that results in a Vault path, see Template.
+	// {p} is the vault.mmlt.nl/inject-path annotation value.
+	// Example: "secret/{nslabel:team|lower}/{ns}/{p}"
+	VaultSecretPath string
+
+	// TemplateEnv provides the cluster name and namespace lookups for templates.
+	TemplateEnv TemplateEnv
+
+	// Policy restricts the backends, roles and paths a namespace can use, nil allows all.
+	Policy *policy.Checker
+}
+
+// NewSettings returns Settings with templates t.
+func NewSettings(t Templates) *Settings {
+	return &Settings{t: t}
+}
+
+// Settings hold the Templates that are shared by the webhook and the controllers.
+// Templates are replaced when the configuration is reloaded, requests in progress keep using the Templates they got.
+type Settings struct {
+	mu sync.RWMutex
+	t  Templates
+}
+
+// Get returns the current Templates.
+func (s *Settings) Get() Templates {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t
+}
+
+// Replace the Templates.
+func (s *Settings) Replace(t Templates) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.t = t
+}
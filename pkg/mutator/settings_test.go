@@ -0,0 +1,83 @@
+package mutator
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/mmlt/vault-secret/pkg/vault"
+	"github.com/stretchr/testify/assert"
+	"testing"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// FakeBackend returns values "<role>:<path>".
+type fakeBackend struct{}
+
+func (b *fakeBackend) Login(req vault.LoginRequest) (vault.Getter, error) {
+	return vault.GetterFunc(func(path string) (map[string]string, error) {
+		return map[string]string{"v": req.Role + ":" + path}, nil
+	}), nil
+}
+
+func TestSettings(t *testing.T) {
+	clnt := fake.NewFakeClient(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}})
+	settings := NewSettings(Templates{
+		VaultAuthPath:   "kubernetes",
+		VaultRole:       "vaultsecret-{ns}",
+		VaultSecretPath: "secret/{ns}/{p}",
+		TemplateEnv:     TemplateEnv{Client: clnt},
+	})
+	m := &SecretMutator{
+		Backends: vault.NewBackends(&fakeBackend{}),
+		Settings: settings,
+		Log:      logf.Log,
+	}
+	d, err := admission.NewDecoder(runtime.NewScheme())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, m.InjectDecoder(d))
+
+	handle := func() string {
+		raw, err := json.Marshal(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "app",
+			Annotations: map[string]string{
+				"vault.mmlt.nl/inject":        "true",
+				"vault.mmlt.nl/inject-path":   "app",
+				"vault.mmlt.nl/inject-fields": "v=v",
+			},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := m.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1beta1.AdmissionRequest{
+			Namespace: "default",
+			Object:    runtime.RawExtension{Raw: raw},
+		}})
+		if !assert.True(t, resp.Allowed, "%v", resp.Result) || !assert.Len(t, resp.Patches, 1) {
+			return ""
+		}
+		v, _ := resp.Patches[0].Value.(map[string]interface{})
+		s, _ := v["v"].(string)
+		b, err := base64.StdEncoding.DecodeString(s)
+		assert.NoError(t, err)
+		return string(b)
+	}
+
+	t.Run("should_use_replaced_templates", func(t *testing.T) {
+		assert.Equal(t, "vaultsecret-default:secret/default/app", handle())
+
+		tmpl := settings.Get()
+		tmpl.VaultRole = "other-{ns}"
+		settings.Replace(tmpl)
+		assert.Equal(t, "other-default:secret/default/app", handle())
+	})
+}
@@ -0,0 +1,310 @@
+package mutator
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Template is a parsed role or path template.
+//
+// A template is text with {variable} references, a variable can be followed by functions: {variable|func|func}.
+// Variables:
+// - ns is the namespace of the Secret or VaultSecret.
+// - n is the name of the Secret or VaultSecret.
+// - p is the path annotation or field value, it must be a relative path, see ValidatePath.
+// - cluster is the cluster name.
+// - label:<key>, annotation:<key> are labels and annotations of the Secret or VaultSecret.
+// - nslabel:<key>, nsannotation:<key> are labels and annotations of the namespace.
+// Functions:
+// - lower, upper change the case.
+// - trunc:<n> truncates to n characters.
+// - default:<value> replaces an empty value.
+// A reference to a label or annotation that doesn't exist is an error unless a default is given.
+// The result is canonicalized (duplicate and trailing slashes are removed) and must not contain "." or ".." elements
+// or control characters.
+type Template struct {
+	text  string
+	parts []part
+}
+
+// Part is a literal text or a variable reference.
+type part struct {
+	literal string
+
+	variable string
+	key      string
+	funcs    []fn
+}
+
+// Fn is a function applied to a variable value.
+type fn struct {
+	name string
+	arg  string
+}
+
+// ParseTemplate parses text into a Template.
+func ParseTemplate(text string) (*Template, error) {
+	t := &Template{text: text}
+	s := text
+	for len(s) > 0 {
+		i := strings.IndexAny(s, "{}")
+		if i < 0 {
+			t.parts = append(t.parts, part{literal: s})
+			break
+		}
+		if s[i] == '}' {
+			return nil, fmt.Errorf("template %q: unexpected }", text)
+		}
+		if i > 0 {
+			t.parts = append(t.parts, part{literal: s[:i]})
+		}
+		s = s[i+1:]
+		j := strings.IndexAny(s, "{}")
+		if j < 0 || s[j] != '}' {
+			return nil, fmt.Errorf("template %q: missing }", text)
+		}
+		p, err := parseRef(s[:j])
+		if err != nil {
+			return nil, fmt.Errorf("template %q: %w", text, err)
+		}
+		t.parts = append(t.parts, p)
+		s = s[j+1:]
+	}
+	return t, nil
+}
+
+// ParseRef parses a variable reference like "nslabel:team|lower".
+func parseRef(ref string) (part, error) {
+	elems := strings.Split(ref, "|")
+
+	var p part
+	kv := strings.SplitN(elems[0], ":", 2)
+	p.variable = kv[0]
+	switch p.variable {
+	case "ns", "n", "p", "cluster":
+		if len(kv) == 2 {
+			return p, fmt.Errorf("{%s} doesn't take an argument", p.variable)
+		}
+	case "label", "annotation", "nslabel", "nsannotation":
+		if len(kv) != 2 || kv[1] == "" {
+			return p, fmt.Errorf("{%s} requires a key, for example {%s:app}", p.variable, p.variable)
+		}
+		p.key = kv[1]
+	default:
+		return p, fmt.Errorf("unknown variable {%s}", elems[0])
+	}
+
+	for _, e := range elems[1:] {
+		kv := strings.SplitN(e, ":", 2)
+		f := fn{name: kv[0]}
+		if len(kv) == 2 {
+			f.arg = kv[1]
+		}
+		switch f.name {
+		case "lower", "upper":
+		case "trunc":
+			if n, err := strconv.Atoi(f.arg); err != nil || n < 1 {
+				return p, fmt.Errorf("trunc requires a positive length, for example trunc:63")
+			}
+		case "default":
+		default:
+			return p, fmt.Errorf("unknown function %s", f.name)
+		}
+		p.funcs = append(p.funcs, f)
+	}
+
+	return p, nil
+}
+
+// NeedsNamespace returns true when the template refers to namespace labels or annotations.
+func (t *Template) NeedsNamespace() bool {
+	for _, p := range t.parts {
+		if p.variable == "nslabel" || p.variable == "nsannotation" {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the text of the template.
+func (t *Template) String() string {
+	return t.text
+}
+
+// TemplateData are the values of template variables.
+type TemplateData struct {
+	Namespace string
+	Name      string
+	Path      string
+	Cluster   string
+
+	Labels      map[string]string
+	Annotations map[string]string
+
+	NamespaceLabels      map[string]string
+	NamespaceAnnotations map[string]string
+}
+
+// Execute returns the template text with variables replaced by values from d.
+func (t *Template) Execute(d *TemplateData) (string, error) {
+	var sb strings.Builder
+	for _, p := range t.parts {
+		if p.variable == "" {
+			sb.WriteString(p.literal)
+			continue
+		}
+
+		if p.variable == "p" {
+			if err := ValidatePath(d.Path); err != nil {
+				return "", err
+			}
+		}
+
+		v, ok := d.value(p.variable, p.key)
+		for _, f := range p.funcs {
+			switch f.name {
+			case "lower":
+				v = strings.ToLower(v)
+			case "upper":
+				v = strings.ToUpper(v)
+			case "trunc":
+				n, _ := strconv.Atoi(f.arg)
+				if r := []rune(v); len(r) > n {
+					v = string(r[:n])
+				}
+			case "default":
+				if v == "" {
+					v, ok = f.arg, true
+				}
+			}
+		}
+		if !ok {
+			return "", fmt.Errorf("template %q: %s %s not set", t.text, p.variable, p.key)
+		}
+
+		sb.WriteString(v)
+	}
+
+	r, err := canonical(sb.String())
+	if err != nil {
+		return "", fmt.Errorf("template %q: %w", t.text, err)
+	}
+	return r, nil
+}
+
+// Value returns the value of variable (with key) and false when it doesn't exist.
+func (d *TemplateData) value(variable, key string) (string, bool) {
+	var m map[string]string
+	switch variable {
+	case "ns":
+		return d.Namespace, true
+	case "n":
+		return d.Name, true
+	case "p":
+		return d.Path, true
+	case "cluster":
+		return d.Cluster, true
+	case "label":
+		m = d.Labels
+	case "annotation":
+		m = d.Annotations
+	case "nslabel":
+		m = d.NamespaceLabels
+	case "nsannotation":
+		m = d.NamespaceAnnotations
+	}
+	v, ok := m[key]
+	return v, ok
+}
+
+// ValidatePath returns an error when p (typically an annotation value) is not a relative path or when it contains
+// "." or ".." elements, template references or control characters.
+func ValidatePath(p string) error {
+	if strings.HasPrefix(p, "/") {
+		return fmt.Errorf("path %q: must be relative", p)
+	}
+	if strings.ContainsAny(p, "{}") {
+		return fmt.Errorf("path %q: must not contain template references", p)
+	}
+	_, err := canonical(p)
+	return err
+}
+
+// Canonical returns p without duplicate and trailing slashes.
+// An error is returned when p contains "." or ".." elements, control characters or invalid UTF-8.
+func canonical(p string) (string, error) {
+	if !utf8.ValidString(p) {
+		return "", fmt.Errorf("path %q: invalid UTF-8", p)
+	}
+	for _, r := range p {
+		if unicode.IsControl(r) {
+			return "", fmt.Errorf("path %q: must not contain control characters", p)
+		}
+	}
+
+	elems := strings.Split(p, "/")
+	r := make([]string, 0, len(elems))
+	for i, e := range elems {
+		switch e {
+		case ".", "..":
+			return "", fmt.Errorf("path %q: must not contain %s", p, e)
+		case "":
+			// keep a leading slash
+			if i == 0 {
+				r = append(r, e)
+			}
+			continue
+		}
+		r = append(r, e)
+	}
+	return strings.Join(r, "/"), nil
+}
+
+// TemplateEnv provides the values of template variables that are not part of the Secret or VaultSecret.
+type TemplateEnv struct {
+	// Client reads Namespaces, typically the (cached) client of the manager.
+	Client client.Reader
+	// Cluster is the value of {cluster}.
+	Cluster string
+}
+
+// Render parses and executes template text for obj with path as {p}.
+func (e *TemplateEnv) Render(ctx context.Context, text string, obj metav1.Object, path string) (string, error) {
+	t, err := ParseTemplate(text)
+	if err != nil {
+		return "", err
+	}
+
+	d := &TemplateData{
+		Namespace:   obj.GetNamespace(),
+		Name:        obj.GetName(),
+		Path:        path,
+		Cluster:     e.Cluster,
+		Labels:      obj.GetLabels(),
+		Annotations: obj.GetAnnotations(),
+	}
+
+	if t.NeedsNamespace() {
+		if e.Client == nil {
+			return "", fmt.Errorf("template %q: namespace lookups are not configured", text)
+		}
+		ns := &corev1.Namespace{}
+		err = e.Client.Get(ctx, types.NamespacedName{Name: obj.GetNamespace()}, ns)
+		if err != nil {
+			return "", fmt.Errorf("template %q: %w", text, err)
+		}
+		d.NamespaceLabels = ns.Labels
+		d.NamespaceAnnotations = ns.Annotations
+	}
+
+	return t.Execute(d)
+}
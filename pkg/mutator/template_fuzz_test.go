@@ -0,0 +1,24 @@
+//go:build go1.18
+// +build go1.18
+
+package mutator
+
+import (
+	"testing"
+)
+
+// FuzzParseTemplate checks that parsing and executing arbitrary templates doesn't panic.
+func FuzzParseTemplate(f *testing.F) {
+	for _, s := range malformedTemplates {
+		f.Add(s)
+	}
+	f.Fuzz(checkTemplate)
+}
+
+// FuzzValidatePath checks that no valid inject-path value escapes the namespace prefix of a path template.
+func FuzzValidatePath(f *testing.F) {
+	for _, s := range escapingPaths {
+		f.Add(s)
+	}
+	f.Fuzz(checkPath)
+}
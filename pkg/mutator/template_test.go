@@ -0,0 +1,209 @@
+package mutator
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"unicode"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestParseTemplate(t *testing.T) {
+	tests := []struct {
+		it      string
+		text    string
+		wantErr string
+	}{
+		{it: "should_parse_literal", text: "secret/data"},
+		{it: "should_parse_variables_and_functions", text: "vs-{nslabel:team|lower|trunc:10|default:x}-{ns}"},
+		{it: "should_error_on_missing_brace", text: "vs-{ns", wantErr: `template "vs-{ns": missing }`},
+		{it: "should_error_on_unexpected_brace", text: "vs-ns}", wantErr: `template "vs-ns}": unexpected }`},
+		{it: "should_error_on_unknown_variable", text: "{team}", wantErr: `template "{team}": unknown variable {team}`},
+		{it: "should_error_on_missing_key", text: "{nslabel}", wantErr: `template "{nslabel}": {nslabel} requires a key, for example {nslabel:app}`},
+		{it: "should_error_on_unknown_function", text: "{ns|title}", wantErr: `template "{ns|title}": unknown function title`},
+		{it: "should_error_on_invalid_trunc", text: "{ns|trunc:x}", wantErr: `template "{ns|trunc:x}": trunc requires a positive length, for example trunc:63`},
+	}
+	for _, tst := range tests {
+		t.Run(tst.it, func(t *testing.T) {
+			_, err := ParseTemplate(tst.text)
+			if tst.wantErr != "" {
+				assert.EqualError(t, err, tst.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	env := &TemplateEnv{
+		Client: fake.NewFakeClient(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:        "default",
+			Labels:      map[string]string{"team": "Blue"},
+			Annotations: map[string]string{"cost-center": "1234567890"},
+		}}),
+		Cluster: "prod",
+	}
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Namespace:   "default",
+		Name:        "db",
+		Labels:      map[string]string{"app": "shop"},
+		Annotations: map[string]string{"team": ".."},
+	}}
+
+	tests := []struct {
+		it      string
+		text    string
+		path    string
+		want    string
+		wantErr string
+	}{
+		{
+			it:   "should_replace_ns_n_p",
+			text: "secret/{ns}/{n}/{p}",
+			path: "creds",
+			want: "secret/default/db/creds",
+		},
+		{
+			it:   "should_canonicalize_slashes",
+			text: "secret//{ns}/{p}",
+			path: "a//b/",
+			want: "secret/default/a/b",
+		},
+		{
+			it:      "should_reject_traversal_in_p",
+			text:    "secret/{ns}/{p}",
+			path:    "../other-ns/db",
+			wantErr: `path "../other-ns/db": must not contain ..`,
+		},
+		{
+			it:      "should_reject_absolute_p",
+			text:    "secret/{ns}/{p}",
+			path:    "/sys/policy",
+			wantErr: `path "/sys/policy": must be relative`,
+		},
+		{
+			it:      "should_reject_template_references_in_p",
+			text:    "secret/{ns}/{p}",
+			path:    "{ns}/db",
+			wantErr: `path "{ns}/db": must not contain template references`,
+		},
+		{
+			it:      "should_reject_control_characters_in_p",
+			text:    "secret/{ns}/{p}",
+			path:    "db\n",
+			wantErr: `path "db\n": must not contain control characters`,
+		},
+		{
+			it:      "should_reject_traversal_via_annotation",
+			text:    "secret/{annotation:team}/{p}",
+			path:    "db",
+			wantErr: `template "secret/{annotation:team}/{p}": path "secret/../db": must not contain ..`,
+		},
+		{
+			it:   "should_replace_cluster_and_labels",
+			text: "{cluster}/{nslabel:team|lower}/{label:app|upper}",
+			want: "prod/blue/SHOP",
+		},
+		{
+			it:   "should_truncate",
+			text: "cc-{nsannotation:cost-center|trunc:4}",
+			want: "cc-1234",
+		},
+		{
+			it:   "should_use_default_for_missing_label",
+			text: "{label:tier|default:web}",
+			want: "web",
+		},
+		{
+			it:      "should_error_on_missing_label",
+			text:    "{nslabel:tier}",
+			wantErr: `template "{nslabel:tier}": nslabel tier not set`,
+		},
+	}
+	for _, tst := range tests {
+		t.Run(tst.it, func(t *testing.T) {
+			got, err := env.Render(context.Background(), tst.text, secret, tst.path)
+			if tst.wantErr != "" {
+				assert.EqualError(t, err, tst.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tst.want, got)
+		})
+	}
+}
+
+// MalformedTemplates are parsed by TestParseTemplateMalformed and seed FuzzParseTemplate.
+var malformedTemplates = []string{
+	"vaultsecret-{ns}",
+	"secret/{nslabel:team|lower|trunc:3|default:x}/{p}",
+	"{", "}{", "{ns|}", "{}", "{{ns}}", "{ns", "ns}",
+	"{nslabel}", "{nslabel:}", "{ns|trunc}", "{ns|trunc:x}", "{ns|trunc:-1}", "{ns|default}",
+	"{ns|unknown}", "{unknown}", "{ns||lower}", "|", ":", "\x00{ns}", "{é}",
+}
+
+// TestParseTemplateMalformed checks that parsing and executing malformed templates doesn't panic.
+func TestParseTemplateMalformed(t *testing.T) {
+	for _, text := range malformedTemplates {
+		t.Run(text, func(t *testing.T) {
+			checkTemplate(t, text)
+		})
+	}
+}
+
+// CheckTemplate parses and executes text.
+func checkTemplate(t *testing.T, text string) {
+	tmpl, err := ParseTemplate(text)
+	if err != nil {
+		return
+	}
+	_, _ = tmpl.Execute(&TemplateData{Namespace: "default", Name: "db", Path: "p"})
+}
+
+// EscapingPaths are rendered by TestRenderPathEscape and seed FuzzValidatePath.
+var escapingPaths = []string{
+	"db", "a/b", "../other/db", "a/../../b", "/abs", "{ns}", "a//b/", "a/./b", "\x00", "é",
+	"..", ".", "/", "//", "./..", "a/..", "a/../..", "../../../etc/passwd", "%2e%2e/db", "a\\..\\b",
+	"a/\n/b", "\t", "{", "}", "{p}", "db\x7f",
+}
+
+// TestRenderPathEscape checks that inject-path values don't escape the namespace prefix of a path template.
+func TestRenderPathEscape(t *testing.T) {
+	for _, p := range escapingPaths {
+		t.Run(p, func(t *testing.T) {
+			checkPath(t, p)
+		})
+	}
+}
+
+// CheckPath fails t when a valid path p escapes the namespace prefix of a path template or results in a non
+// canonical path.
+func checkPath(t *testing.T, p string) {
+	if ValidatePath(p) != nil {
+		return
+	}
+	env := &TemplateEnv{}
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db"}}
+	got, err := env.Render(context.Background(), "secret/{ns}/{p}", secret, p)
+	if err != nil {
+		return
+	}
+	if !strings.HasPrefix(got, "secret/default/") && got != "secret/default" {
+		t.Fatalf("path %q escapes: %q", p, got)
+	}
+	for _, e := range strings.Split(got, "/") {
+		if e == ".." || e == "." || e == "" {
+			t.Fatalf("path %q results in non canonical %q", p, got)
+		}
+	}
+	for _, r := range got {
+		if unicode.IsControl(r) || r == '{' || r == '}' {
+			t.Fatalf("path %q results in %q", p, got)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Package policy evaluates VaultSecretPolicy resources.
+package policy
+
+import (
+	"context"
+	"fmt"
+	vaultv1alpha1 "github.com/mmlt/vault-secret/api/v1alpha1"
+	"path"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// +kubebuilder:rbac:groups=vault.mmlt.nl,resources=vaultsecretpolicies,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+
+// Request describes the use of a backend by a namespace.
+type Request struct {
+	// Namespace of the Secret or VaultSecret.
+	Namespace string
+	// Backend name, "" is the default backend.
+	Backend string
+	// Role after applying the role template.
+	Role string
+	// Path after applying the path template, empty when no path is accessed.
+	Path string
+}
+
+// Checker checks requests against the VaultSecretPolicies in the cluster.
+type Checker struct {
+	Client client.Reader
+}
+
+// Check returns an empty string when req is allowed by at least one of the policies that select its namespace,
+// otherwise it returns the reason why req is denied.
+func (c *Checker) Check(ctx context.Context, req Request) (string, error) {
+	ns := &corev1.Namespace{}
+	err := c.Client.Get(ctx, types.NamespacedName{Name: req.Namespace}, ns)
+	if err != nil {
+		return "", fmt.Errorf("get namespace: %w", err)
+	}
+
+	list := &vaultv1alpha1.VaultSecretPolicyList{}
+	err = c.Client.List(ctx, list)
+	if err != nil {
+		return "", fmt.Errorf("list VaultSecretPolicies: %w", err)
+	}
+	// evaluate in a stable order to get stable reasons.
+	sort.Slice(list.Items, func(i, j int) bool { return list.Items[i].Name < list.Items[j].Name })
+
+	var reasons []string
+	for _, p := range list.Items {
+		sel, err := metav1.LabelSelectorAsSelector(&p.Spec.NamespaceSelector)
+		if err != nil {
+			reasons = append(reasons, fmt.Sprintf("%s: invalid namespaceSelector: %v", p.Name, err))
+			continue
+		}
+		if !sel.Matches(labels.Set(ns.Labels)) {
+			continue
+		}
+
+		r := check(&p.Spec, req)
+		if r == "" {
+			return "", nil
+		}
+		reasons = append(reasons, p.Name+": "+r)
+	}
+
+	if len(reasons) == 0 {
+		return fmt.Sprintf("no VaultSecretPolicy selects namespace %s", req.Namespace), nil
+	}
+	return "denied by VaultSecretPolicy " + strings.Join(reasons, "; "), nil
+}
+
+// Check returns an empty string when req is allowed by spec, otherwise it returns the reason why req is denied.
+func check(spec *vaultv1alpha1.VaultSecretPolicySpec, req Request) string {
+	if len(spec.AllowedBackends) > 0 && !contains(spec.AllowedBackends, req.Backend) {
+		return fmt.Sprintf("backend %q is not allowed", req.Backend)
+	}
+	if len(spec.AllowedRoles) > 0 && !matchAny(spec.AllowedRoles, req.Role, req.Namespace) {
+		return fmt.Sprintf("role %q is not allowed", req.Role)
+	}
+	if req.Path != "" && len(spec.AllowedPaths) > 0 && !matchAny(spec.AllowedPaths, req.Path, req.Namespace) {
+		return fmt.Sprintf("path %q is not allowed", req.Path)
+	}
+	return ""
+}
+
+// MatchAny returns true when s matches one of the globs.
+// {ns} in a glob is replaced by namespace.
+func matchAny(globs []string, s, namespace string) bool {
+	// never allow a path to escape a glob.
+	for _, e := range strings.Split(s, "/") {
+		if e == ".." {
+			return false
+		}
+	}
+
+	for _, g := range globs {
+		g = strings.ReplaceAll(g, "{ns}", namespace)
+		if strings.HasSuffix(g, "/**") {
+			if strings.HasPrefix(s, strings.TrimSuffix(g, "**")) {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(g, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
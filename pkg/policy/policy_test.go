@@ -0,0 +1,88 @@
+package policy
+
+import (
+	"context"
+	vaultv1alpha1 "github.com/mmlt/vault-secret/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCheck(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = vaultv1alpha1.AddToScheme(scheme)
+
+	namespace := func(name string, labels map[string]string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+	c := &Checker{Client: fake.NewFakeClientWithScheme(scheme,
+		namespace("team-a", map[string]string{"tenant": "true"}),
+		namespace("infra", nil),
+		&vaultv1alpha1.VaultSecretPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "tenants"},
+			Spec: vaultv1alpha1.VaultSecretPolicySpec{
+				NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "true"}},
+				AllowedPaths:      []string{"secret/data/ns/{ns}/**", "secret/data/shared/*"},
+				AllowedRoles:      []string{"vaultsecret-{ns}"},
+				AllowedBackends:   []string{""},
+			},
+		},
+	)}
+
+	tests := []struct {
+		it   string
+		req  Request
+		want string
+	}{
+		{
+			it:  "should_allow_path_below_own_namespace",
+			req: Request{Namespace: "team-a", Role: "vaultsecret-team-a", Path: "secret/data/ns/team-a/db/creds"},
+		},
+		{
+			it:  "should_allow_path_matching_single_element_glob",
+			req: Request{Namespace: "team-a", Role: "vaultsecret-team-a", Path: "secret/data/shared/ca"},
+		},
+		{
+			it:  "should_allow_request_without_path",
+			req: Request{Namespace: "team-a", Role: "vaultsecret-team-a"},
+		},
+		{
+			it:   "should_deny_path_of_other_namespace",
+			req:  Request{Namespace: "team-a", Role: "vaultsecret-team-a", Path: "secret/data/ns/team-b/db"},
+			want: `denied by VaultSecretPolicy tenants: path "secret/data/ns/team-b/db" is not allowed`,
+		},
+		{
+			it:   "should_deny_path_escaping_glob",
+			req:  Request{Namespace: "team-a", Role: "vaultsecret-team-a", Path: "secret/data/ns/team-a/../team-b/db"},
+			want: `denied by VaultSecretPolicy tenants: path "secret/data/ns/team-a/../team-b/db" is not allowed`,
+		},
+		{
+			it:   "should_deny_role_of_other_namespace",
+			req:  Request{Namespace: "team-a", Role: "vaultsecret-team-b", Path: "secret/data/ns/team-a/db"},
+			want: `denied by VaultSecretPolicy tenants: role "vaultsecret-team-b" is not allowed`,
+		},
+		{
+			it:   "should_deny_backend_not_listed",
+			req:  Request{Namespace: "team-a", Backend: "files", Role: "vaultsecret-team-a", Path: "secret/data/ns/team-a/db"},
+			want: `denied by VaultSecretPolicy tenants: backend "files" is not allowed`,
+		},
+		{
+			it:   "should_deny_namespace_without_policy",
+			req:  Request{Namespace: "infra", Role: "vaultsecret-infra", Path: "secret/data/ns/infra/db"},
+			want: "no VaultSecretPolicy selects namespace infra",
+		},
+	}
+	for _, tst := range tests {
+		t.Run(tst.it, func(t *testing.T) {
+			got, err := c.Check(context.Background(), tst.req)
+			assert.NoError(t, err)
+			assert.Equal(t, tst.want, got)
+		})
+	}
+}
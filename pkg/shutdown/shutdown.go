@@ -0,0 +1,122 @@
+// Package shutdown lets a replica stop without failing the admission requests it's serving.
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/runtime/inject"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// Drainer tracks in-flight admission requests so they can complete before the process exits.
+type Drainer struct {
+	mu       sync.Mutex
+	inFlight int
+	draining bool
+}
+
+// Handler returns h wrapped so its in-flight requests are tracked.
+func (d *Drainer) Handler(h admission.Handler) admission.Handler {
+	return &handler{handler: h, drainer: d}
+}
+
+// SignalHandler returns a stop channel that is closed delay after SIGTERM or SIGINT is received.
+// During the delay requests are still served so the API server has time to stop sending requests to this replica.
+// A second signal exits the process immediately.
+func (d *Drainer) SignalHandler(delay time.Duration) <-chan struct{} {
+	stop := make(chan struct{})
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		<-c
+		d.mu.Lock()
+		d.draining = true
+		d.mu.Unlock()
+
+		select {
+		case <-time.After(delay):
+		case <-c:
+			os.Exit(1)
+		}
+		close(stop)
+
+		<-c
+		os.Exit(1)
+	}()
+	return stop
+}
+
+// Draining returns true when a shutdown signal has been received.
+func (d *Drainer) Draining() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.draining
+}
+
+// Check is a readiness check that fails when a shutdown signal has been received.
+// It takes the replica out of the webhook Service endpoints during the shutdown delay.
+func (d *Drainer) Check(_ *http.Request) error {
+	if d.Draining() {
+		return errors.New("shutting down")
+	}
+	return nil
+}
+
+// Wait waits for in-flight requests to complete.
+// It returns false when requests are still in flight after timeout.
+func (d *Drainer) Wait(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		d.mu.Lock()
+		n := d.inFlight
+		d.mu.Unlock()
+		if n == 0 {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// Handler is an admission.Handler that tracks in-flight requests.
+type handler struct {
+	handler admission.Handler
+	drainer *Drainer
+}
+
+// Handle passes req to the wrapped handler.
+func (h *handler) Handle(ctx context.Context, req admission.Request) admission.Response {
+	h.drainer.mu.Lock()
+	h.drainer.inFlight++
+	h.drainer.mu.Unlock()
+	defer func() {
+		h.drainer.mu.Lock()
+		h.drainer.inFlight--
+		h.drainer.mu.Unlock()
+	}()
+
+	return h.handler.Handle(ctx, req)
+}
+
+// InjectDecoder passes the decoder to the wrapped handler.
+func (h *handler) InjectDecoder(d *admission.Decoder) error {
+	_, err := admission.InjectDecoderInto(d, h.handler)
+	return err
+}
+
+// InjectFunc passes injected fields to the wrapped handler.
+func (h *handler) InjectFunc(f inject.Func) error {
+	return f(h.handler)
+}
+
+var _ admission.DecoderInjector = &handler{}
+var _ inject.Injector = &handler{}
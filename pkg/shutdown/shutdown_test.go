@@ -0,0 +1,66 @@
+package shutdown
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// FakeHandler blocks until release is closed.
+type fakeHandler struct {
+	release chan struct{}
+	decoder *admission.Decoder
+}
+
+func (h *fakeHandler) Handle(_ context.Context, _ admission.Request) admission.Response {
+	<-h.release
+	return admission.Allowed("")
+}
+
+func (h *fakeHandler) InjectDecoder(d *admission.Decoder) error {
+	h.decoder = d
+	return nil
+}
+
+func TestDrainer(t *testing.T) {
+	t.Run("should_wait_for_in_flight_requests", func(t *testing.T) {
+		d := &Drainer{}
+		h := &fakeHandler{release: make(chan struct{})}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			d.Handler(h).Handle(context.Background(), admission.Request{})
+		}()
+		// wait for the request to be in flight.
+		for d.Wait(0) {
+			time.Sleep(time.Millisecond)
+		}
+
+		assert.False(t, d.Wait(10*time.Millisecond))
+		close(h.release)
+		assert.True(t, d.Wait(time.Second))
+		<-done
+	})
+
+	t.Run("should_inject_decoder_into_wrapped_handler", func(t *testing.T) {
+		d := &Drainer{}
+		h := &fakeHandler{}
+		dec, err := admission.NewDecoder(runtime.NewScheme())
+		assert.NoError(t, err)
+
+		ok, err := admission.InjectDecoderInto(dec, d.Handler(h))
+		assert.True(t, ok)
+		assert.NoError(t, err)
+		assert.Equal(t, dec, h.decoder)
+	})
+	t.Run("should_fail_readiness_when_draining", func(t *testing.T) {
+		d := &Drainer{}
+		assert.NoError(t, d.Check(nil))
+		d.draining = true
+		assert.EqualError(t, d.Check(nil), "shutting down")
+	})
+}
@@ -0,0 +1,166 @@
+// Package aws provides backends that read secrets from AWS Secrets Manager and SSM Parameter Store.
+package aws
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/mmlt/vault-secret/pkg/vault"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+func init() {
+	vault.Register("aws-secretsmanager", func(options map[string]string) (vault.Loginer, error) {
+		c, err := newClient("secretsmanager", options)
+		if err != nil {
+			return nil, err
+		}
+		return &SecretsManager{client: c}, nil
+	})
+	vault.Register("aws-ssm", func(options map[string]string) (vault.Loginer, error) {
+		c, err := newClient("ssm", options)
+		if err != nil {
+			return nil, err
+		}
+		return &SSM{client: c}, nil
+	})
+}
+
+// NewClient returns a client for an AWS service configured with options:
+// - region is the AWS region, defaults to $AWS_REGION.
+// - endpoint is the URL of the service, defaults to https://<service>.<region>.amazonaws.com
+// - access-key-id, secret-access-key and session-token are static credentials, default to $AWS_ACCESS_KEY_ID etc.
+// - role-arn and web-identity-token-file are used to assume a role with web identity (IRSA), default to
+// $AWS_ROLE_ARN and $AWS_WEB_IDENTITY_TOKEN_FILE. Web identity takes precedence over static credentials.
+// - sts-endpoint is the URL of the STS service, defaults to https://sts.<region>.amazonaws.com
+func newClient(service string, options map[string]string) (*client, error) {
+	opt := func(name, env string) string {
+		if v := options[name]; v != "" {
+			return v
+		}
+		return os.Getenv(env)
+	}
+
+	region := opt("region", "AWS_REGION")
+	if region == "" {
+		return nil, fmt.Errorf("region is required")
+	}
+
+	endpoint := options["endpoint"]
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.%s.amazonaws.com", service, region)
+	}
+
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+
+	var creds credentialsProvider
+	if arn, tf := opt("role-arn", "AWS_ROLE_ARN"), opt("web-identity-token-file", "AWS_WEB_IDENTITY_TOKEN_FILE"); arn != "" && tf != "" {
+		sts := options["sts-endpoint"]
+		if sts == "" {
+			sts = fmt.Sprintf("https://sts.%s.amazonaws.com", region)
+		}
+		creds = &webIdentityProvider{
+			endpoint:  sts,
+			roleARN:   arn,
+			tokenFile: tf,
+			client:    httpClient,
+		}
+	} else {
+		c := credentials{
+			AccessKeyID:     opt("access-key-id", "AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: opt("secret-access-key", "AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    opt("session-token", "AWS_SESSION_TOKEN"),
+		}
+		if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+			return nil, fmt.Errorf("no credentials, set role-arn and web-identity-token-file or access-key-id and secret-access-key")
+		}
+		creds = staticProvider(c)
+	}
+
+	return &client{
+		service:  service,
+		region:   region,
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		creds:    creds,
+		http:     httpClient,
+	}, nil
+}
+
+// Client calls AWS JSON protocol APIs.
+type client struct {
+	service  string
+	region   string
+	endpoint string
+	creds    credentialsProvider
+	http     *http.Client
+}
+
+// AwsError is the error returned by AWS JSON APIs.
+type awsError struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+func (e *awsError) Error() string {
+	t := e.Type
+	// the type can be prefixed with a namespace, like "com.amazonaws.secretsmanager#ResourceNotFoundException"
+	if i := strings.LastIndex(t, "#"); i >= 0 {
+		t = t[i+1:]
+	}
+	return t + ": " + e.Message
+}
+
+func (e *awsError) notFound() bool {
+	return strings.HasSuffix(e.Type, "ResourceNotFoundException") || strings.HasSuffix(e.Type, "ParameterNotFound")
+}
+
+// Do calls API target with in as request and decodes the response into out.
+func (c *client) do(target string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", target)
+
+	creds, err := c.creds.credentials()
+	if err != nil {
+		return fmt.Errorf("credentials: %w", err)
+	}
+	sign(req, body, creds, c.region, c.service, time.Now())
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return vault.Unavailable(err)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return vault.Unavailable(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		e := &awsError{}
+		if json.Unmarshal(b, e) != nil || e.Type == "" {
+			err = fmt.Errorf("%s: %s", resp.Status, string(b))
+		} else {
+			err = e
+		}
+		if vault.UnavailableStatus(resp.StatusCode) {
+			err = vault.Unavailable(err)
+		}
+		return err
+	}
+
+	return json.Unmarshal(b, out)
+}
@@ -0,0 +1,183 @@
+package aws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mmlt/vault-secret/pkg/vault"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSign checks the signature against the example in the AWS General Reference.
+func TestSign(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+	sign(req, nil, credentials{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}, "us-east-1", "iam", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, "+
+		"SignedHeaders=content-type;host;x-amz-date, "+
+		"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		req.Header.Get("Authorization"))
+}
+
+func TestSecretsManager(t *testing.T) {
+	srv := httptest.NewServer(testFakeAWS(t, "AKIDSTATIC"))
+	defer srv.Close()
+
+	b, err := newClient("secretsmanager", map[string]string{
+		"region":            "eu-west-1",
+		"endpoint":          srv.URL,
+		"access-key-id":     "AKIDSTATIC",
+		"secret-access-key": "secret",
+	})
+	assert.NoError(t, err)
+	sm := &SecretsManager{client: b}
+
+	t.Run("should_get_json_fields", func(t *testing.T) {
+		got, err := sm.Get("app/db")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"user": "superman", "port": "5432"}, got)
+	})
+
+	t.Run("should_get_plain_value", func(t *testing.T) {
+		got, err := sm.Get("app/token")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"value": "plain-token"}, got)
+	})
+
+	t.Run("should_error_on_missing_secret", func(t *testing.T) {
+		_, err := sm.Get("app/missing")
+		assert.EqualError(t, err, "path not found: app/missing")
+	})
+
+	t.Run("should_wrap_unavailable_on_throttling", func(t *testing.T) {
+		_, err := sm.Get("app/throttled")
+		assert.True(t, errors.Is(err, vault.ErrUnavailable), "got %v", err)
+	})
+
+	t.Run("should_wrap_unavailable_on_connection_error", func(t *testing.T) {
+		down, err := newClient("secretsmanager", map[string]string{
+			"region":            "eu-west-1",
+			"endpoint":          "http://127.0.0.1:1",
+			"access-key-id":     "AKIDSTATIC",
+			"secret-access-key": "secret",
+		})
+		assert.NoError(t, err)
+		_, err = (&SecretsManager{client: down}).Get("app/db")
+		assert.True(t, errors.Is(err, vault.ErrUnavailable), "got %v", err)
+	})
+}
+
+func TestSSMWithWebIdentity(t *testing.T) {
+	srv := httptest.NewServer(testFakeAWS(t, "AKIDTEMPORARY"))
+	defer srv.Close()
+
+	tf, err := ioutil.TempFile("", "token")
+	assert.NoError(t, err)
+	defer os.Remove(tf.Name())
+	_, err = tf.WriteString("web-identity-token")
+	assert.NoError(t, err)
+	tf.Close()
+
+	b, err := newClient("ssm", map[string]string{
+		"region":                  "eu-west-1",
+		"endpoint":                srv.URL,
+		"sts-endpoint":            srv.URL,
+		"role-arn":                "arn:aws:iam::123456789012:role/vaultsecret",
+		"web-identity-token-file": tf.Name(),
+	})
+	assert.NoError(t, err)
+	ssm := &SSM{client: b}
+
+	t.Run("should_get_parameters_by_path", func(t *testing.T) {
+		got, err := ssm.Get("app/db")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"user": "superman", "password": "supersecret"}, got)
+	})
+
+	t.Run("should_get_single_parameter", func(t *testing.T) {
+		got, err := ssm.Get("app/token")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"value": "plain-token"}, got)
+	})
+
+	t.Run("should_error_on_missing_parameter", func(t *testing.T) {
+		_, err := ssm.Get("app/missing")
+		assert.Error(t, err)
+	})
+}
+
+// TestFakeAWS returns a handler that fakes STS, Secrets Manager and SSM.
+// Requests must be signed with accessKeyID.
+func testFakeAWS(t *testing.T, accessKeyID string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") == "" {
+			// STS
+			assert.NoError(t, r.ParseForm())
+			assert.Equal(t, "AssumeRoleWithWebIdentity", r.Form.Get("Action"))
+			assert.Equal(t, "web-identity-token", r.Form.Get("WebIdentityToken"))
+			fmt.Fprintf(w, `<AssumeRoleWithWebIdentityResponse><AssumeRoleWithWebIdentityResult><Credentials>
+<AccessKeyId>%s</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>token</SessionToken>
+<Expiration>%s</Expiration></Credentials></AssumeRoleWithWebIdentityResult></AssumeRoleWithWebIdentityResponse>`,
+				accessKeyID, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+			return
+		}
+
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential="+accessKeyID+"/") {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"__type":"UnrecognizedClientException","message":"invalid credentials"}`)
+			return
+		}
+
+		in := map[string]interface{}{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&in))
+		notFound := func(typ string) {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"__type":"%s","message":"not found"}`, typ)
+		}
+
+		switch r.Header.Get("X-Amz-Target") {
+		case "secretsmanager.GetSecretValue":
+			switch in["SecretId"] {
+			case "app/db":
+				fmt.Fprint(w, `{"SecretString":"{\"user\":\"superman\",\"port\":5432}"}`)
+			case "app/token":
+				fmt.Fprint(w, `{"SecretString":"plain-token"}`)
+			case "app/throttled":
+				w.WriteHeader(http.StatusTooManyRequests)
+				fmt.Fprint(w, `{"__type":"ThrottlingException","message":"rate exceeded"}`)
+			default:
+				notFound("ResourceNotFoundException")
+			}
+		case "AmazonSSM.GetParametersByPath":
+			switch {
+			case in["Path"] == "/app/db" && in["NextToken"] == nil:
+				fmt.Fprint(w, `{"Parameters":[{"Name":"/app/db/user","Value":"superman"}],"NextToken":"next"}`)
+			case in["Path"] == "/app/db":
+				fmt.Fprint(w, `{"Parameters":[{"Name":"/app/db/password","Value":"supersecret"}]}`)
+			default:
+				fmt.Fprint(w, `{"Parameters":[]}`)
+			}
+		case "AmazonSSM.GetParameter":
+			if in["Name"] == "/app/token" {
+				fmt.Fprint(w, `{"Parameter":{"Name":"/app/token","Value":"plain-token"}}`)
+				return
+			}
+			notFound("ParameterNotFound")
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package aws
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/mmlt/vault-secret/pkg/vault"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Credentials to sign requests with.
+type credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      time.Time
+}
+
+// CredentialsProvider returns valid credentials.
+type credentialsProvider interface {
+	credentials() (credentials, error)
+}
+
+// StaticProvider provides fixed credentials.
+type staticProvider credentials
+
+func (p staticProvider) credentials() (credentials, error) {
+	return credentials(p), nil
+}
+
+// WebIdentityProvider exchanges a web identity token (like a projected ServiceAccount token) for temporary
+// credentials of an IAM role. This is what IAM Roles for Service Accounts (IRSA) uses.
+type webIdentityProvider struct {
+	// endpoint of STS.
+	endpoint  string
+	roleARN   string
+	tokenFile string
+	client    *http.Client
+
+	mu     sync.Mutex
+	cached credentials
+}
+
+// Credentials returns cached credentials or assumes the role when they (almost) expire.
+func (p *webIdentityProvider) credentials() (credentials, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cached.AccessKeyID != "" && time.Now().Add(5*time.Minute).Before(p.cached.Expiration) {
+		return p.cached, nil
+	}
+
+	c, err := p.assumeRole()
+	if err != nil {
+		return credentials{}, err
+	}
+	p.cached = c
+	return c, nil
+}
+
+// AssumeRole calls STS AssumeRoleWithWebIdentity.
+// The token is read each time because it's rotated by the kubelet.
+func (p *webIdentityProvider) assumeRole() (credentials, error) {
+	token, err := ioutil.ReadFile(p.tokenFile)
+	if err != nil {
+		return credentials{}, err
+	}
+
+	form := url.Values{}
+	form.Set("Action", "AssumeRoleWithWebIdentity")
+	form.Set("Version", "2011-06-15")
+	form.Set("RoleArn", p.roleARN)
+	form.Set("RoleSessionName", fmt.Sprintf("vaultsecret-%d", time.Now().Unix()))
+	form.Set("WebIdentityToken", strings.TrimSpace(string(token)))
+
+	resp, err := p.client.PostForm(strings.TrimSuffix(p.endpoint, "/")+"/", form)
+	if err != nil {
+		return credentials{}, vault.Unavailable(err)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return credentials{}, vault.Unavailable(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("AssumeRoleWithWebIdentity: %s: %s", resp.Status, string(b))
+		if vault.UnavailableStatus(resp.StatusCode) {
+			err = vault.Unavailable(err)
+		}
+		return credentials{}, err
+	}
+
+	var r struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	err = xml.Unmarshal(b, &r)
+	if err != nil {
+		return credentials{}, fmt.Errorf("AssumeRoleWithWebIdentity: %w", err)
+	}
+
+	return credentials(r.Credentials), nil
+}
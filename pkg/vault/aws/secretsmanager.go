@@ -0,0 +1,40 @@
+package aws
+
+import (
+	"fmt"
+	"github.com/mmlt/vault-secret/pkg/vault"
+)
+
+// SecretsManager reads secrets from AWS Secrets Manager.
+// The path is the name or ARN of a secret.
+// A secret string containing a JSON object results in a field per key, other secrets result in a "value" field.
+type SecretsManager struct {
+	client *client
+}
+
+// Login returns the backend itself, access is controlled by the IAM role of the webhook.
+func (s *SecretsManager) Login(_ vault.LoginRequest) (vault.Getter, error) {
+	return s, nil
+}
+
+// Get the current version of the secret at path.
+func (s *SecretsManager) Get(path string) (map[string]string, error) {
+	var out struct {
+		SecretString *string
+		SecretBinary []byte
+	}
+	err := s.client.do("secretsmanager.GetSecretValue", map[string]string{"SecretId": path}, &out)
+	if e, ok := err.(*awsError); ok && e.notFound() {
+		return nil, fmt.Errorf("path not found: %s", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if out.SecretString == nil {
+		return map[string]string{"value": string(out.SecretBinary)}, nil
+	}
+	return vault.ParseJSONValue([]byte(*out.SecretString)), nil
+}
+
+var _ vault.Loginer = &SecretsManager{}
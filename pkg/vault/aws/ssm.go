@@ -0,0 +1,74 @@
+package aws
+
+import (
+	"fmt"
+	"github.com/mmlt/vault-secret/pkg/vault"
+	"strings"
+)
+
+// SSM reads parameters from AWS Systems Manager Parameter Store.
+// The path is a parameter hierarchy, the parameters directly below it become fields named after the last element of
+// the parameter name. When there are no parameters below the path, the parameter at path is returned as "value" field.
+// SecureString parameters are decrypted.
+type SSM struct {
+	client *client
+}
+
+// Login returns the backend itself, access is controlled by the IAM role of the webhook.
+func (s *SSM) Login(_ vault.LoginRequest) (vault.Getter, error) {
+	return s, nil
+}
+
+type ssmParameter struct {
+	Name  string
+	Value string
+}
+
+// Get the parameters at path.
+func (s *SSM) Get(path string) (map[string]string, error) {
+	path = "/" + strings.Trim(path, "/")
+
+	r := map[string]string{}
+	in := map[string]interface{}{
+		"Path":           path,
+		"Recursive":      false,
+		"WithDecryption": true,
+	}
+	for {
+		var out struct {
+			Parameters []ssmParameter
+			NextToken  string
+		}
+		err := s.client.do("AmazonSSM.GetParametersByPath", in, &out)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range out.Parameters {
+			r[p.Name[strings.LastIndex(p.Name, "/")+1:]] = p.Value
+		}
+		if out.NextToken == "" {
+			break
+		}
+		in["NextToken"] = out.NextToken
+	}
+	if len(r) > 0 {
+		return r, nil
+	}
+
+	var out struct {
+		Parameter ssmParameter
+	}
+	err := s.client.do("AmazonSSM.GetParameter", map[string]interface{}{
+		"Name":           path,
+		"WithDecryption": true,
+	}, &out)
+	if e, ok := err.(*awsError); ok && e.notFound() {
+		return nil, fmt.Errorf("path not found: %s", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"value": out.Parameter.Value}, nil
+}
+
+var _ vault.Loginer = &SSM{}
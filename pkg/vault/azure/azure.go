@@ -0,0 +1,287 @@
+// Package azure provides a backend that reads secrets and certificates from Azure Key Vault.
+package azure
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/mmlt/vault-secret/pkg/vault"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/pkcs12"
+)
+
+func init() {
+	vault.Register("azure-keyvault", factory)
+}
+
+const apiVersion = "7.4"
+
+// VaultNameRe matches valid Key Vault names, the name becomes part of the host name of the endpoint.
+var vaultNameRe = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9-]{1,22}[a-zA-Z0-9]$`)
+
+// Factory creates a backend from options:
+// - endpoint is the URL of a Key Vault where {vault} is replaced by the vault name,
+// defaults to https://{vault}.vault.azure.net
+// - tenant-id, client-id and federated-token-file configure workload identity,
+// default to $AZURE_TENANT_ID, $AZURE_CLIENT_ID and $AZURE_FEDERATED_TOKEN_FILE.
+// - authority-host is the URL of Azure AD, defaults to $AZURE_AUTHORITY_HOST or https://login.microsoftonline.com/
+func factory(options map[string]string) (vault.Loginer, error) {
+	opt := func(name, env, def string) string {
+		if v := options[name]; v != "" {
+			return v
+		}
+		if v := os.Getenv(env); v != "" {
+			return v
+		}
+		return def
+	}
+
+	tp := &tokenProvider{
+		authorityHost: opt("authority-host", "AZURE_AUTHORITY_HOST", "https://login.microsoftonline.com/"),
+		tenantID:      opt("tenant-id", "AZURE_TENANT_ID", ""),
+		clientID:      opt("client-id", "AZURE_CLIENT_ID", ""),
+		tokenFile:     opt("federated-token-file", "AZURE_FEDERATED_TOKEN_FILE", ""),
+		client:        &http.Client{Timeout: 10 * time.Second},
+	}
+	if tp.tenantID == "" || tp.clientID == "" || tp.tokenFile == "" {
+		return nil, fmt.Errorf("tenant-id, client-id and federated-token-file are required")
+	}
+
+	return newKeyVault(opt("endpoint", "", "https://{vault}.vault.azure.net"), tp), nil
+}
+
+// NewKeyVault returns a backend that reads from Key Vaults at endpoint using tokens from tp.
+func newKeyVault(endpoint string, tp *tokenProvider) *KeyVault {
+	return &KeyVault{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		tokens:   tp,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// KeyVault reads secrets from Azure Key Vault.
+//
+// The path is <vault-name>/<secret-name>[/<version>].
+// A plain secret results in a "value" field.
+// A secret that backs a certificate (PKCS#12 or PEM content type) results in "tls.crt" and "tls.key" fields.
+type KeyVault struct {
+	endpoint string
+	tokens   *tokenProvider
+	client   *http.Client
+}
+
+// Login returns the backend itself, access is controlled by the workload identity of the webhook.
+func (k *KeyVault) Login(_ vault.LoginRequest) (vault.Getter, error) {
+	return k, nil
+}
+
+// Get the secret at path.
+func (k *KeyVault) Get(path string) (map[string]string, error) {
+	elems := strings.Split(strings.Trim(path, "/"), "/")
+	if len(elems) < 2 || len(elems) > 3 {
+		return nil, fmt.Errorf("path %s: expected <vault-name>/<secret-name>[/<version>]", path)
+	}
+	if !vaultNameRe.MatchString(elems[0]) {
+		return nil, fmt.Errorf("path %s: invalid vault name %q", path, elems[0])
+	}
+	u := strings.ReplaceAll(k.endpoint, "{vault}", elems[0]) + "/secrets/" + url.PathEscape(elems[1])
+	if len(elems) == 3 {
+		u += "/" + url.PathEscape(elems[2])
+	}
+	u += "?api-version=" + apiVersion
+
+	token, err := k.tokens.token()
+	if err != nil {
+		return nil, fmt.Errorf("token: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, vault.Unavailable(err)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, vault.Unavailable(err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("path not found: %s", path)
+	}
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("path %s: %s: %s", path, resp.Status, string(b))
+		if vault.UnavailableStatus(resp.StatusCode) {
+			err = vault.Unavailable(err)
+		}
+		return nil, err
+	}
+
+	var secret struct {
+		Value       string `json:"value"`
+		ContentType string `json:"contentType"`
+	}
+	err = json.Unmarshal(b, &secret)
+	if err != nil {
+		return nil, err
+	}
+
+	switch secret.ContentType {
+	case "application/x-pkcs12":
+		pfx, err := base64.StdEncoding.DecodeString(secret.Value)
+		if err != nil {
+			return nil, fmt.Errorf("path %s: %w", path, err)
+		}
+		blocks, err := pkcs12.ToPEM(pfx, "")
+		if err != nil {
+			return nil, fmt.Errorf("path %s: %w", path, err)
+		}
+		return splitPEM(blocks)
+	case "application/x-pem-file":
+		var blocks []*pem.Block
+		rest := []byte(secret.Value)
+		for {
+			var b *pem.Block
+			b, rest = pem.Decode(rest)
+			if b == nil {
+				break
+			}
+			blocks = append(blocks, b)
+		}
+		return splitPEM(blocks)
+	default:
+		return map[string]string{"value": secret.Value}, nil
+	}
+}
+
+// SplitPEM returns the certificates as "tls.crt" and the private key as "tls.key" field.
+// The key is encoded as PKCS#8.
+func splitPEM(blocks []*pem.Block) (map[string]string, error) {
+	var crt, key []byte
+	for _, b := range blocks {
+		switch {
+		case b.Type == "CERTIFICATE":
+			crt = append(crt, pem.EncodeToMemory(&pem.Block{Type: b.Type, Bytes: b.Bytes})...)
+		case strings.HasSuffix(b.Type, "PRIVATE KEY"):
+			k, err := parsePrivateKey(b.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			der, err := x509.MarshalPKCS8PrivateKey(k)
+			if err != nil {
+				return nil, err
+			}
+			key = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		}
+	}
+	if crt == nil || key == nil {
+		return nil, fmt.Errorf("certificate or key missing")
+	}
+	return map[string]string{
+		"tls.crt": string(crt),
+		"tls.key": string(key),
+	}, nil
+}
+
+// ParsePrivateKey parses a PKCS#8, PKCS#1 or EC private key.
+func parsePrivateKey(der []byte) (interface{}, error) {
+	if k, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return k, nil
+	}
+	if k, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return k, nil
+	}
+	if k, err := x509.ParseECPrivateKey(der); err == nil {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unsupported private key")
+}
+
+// TokenProvider gets Azure AD access tokens for Key Vault using workload identity federation.
+// See https://azure.github.io/azure-workload-identity/docs/
+type tokenProvider struct {
+	authorityHost string
+	tenantID      string
+	clientID      string
+	// tokenFile contains the projected ServiceAccount token.
+	tokenFile string
+	client    *http.Client
+
+	mu      sync.Mutex
+	cached  string
+	expires time.Time
+}
+
+// Token returns a cached access token or requests a new one when it (almost) expires.
+func (p *tokenProvider) token() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cached != "" && time.Now().Add(5*time.Minute).Before(p.expires) {
+		return p.cached, nil
+	}
+
+	// the token file is rotated by the kubelet, read it each time.
+	assertion, err := ioutil.ReadFile(p.tokenFile)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("client_id", p.clientID)
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+	form.Set("client_assertion", strings.TrimSpace(string(assertion)))
+	form.Set("scope", "https://vault.azure.net/.default")
+
+	u := strings.TrimSuffix(p.authorityHost, "/") + "/" + url.PathEscape(p.tenantID) + "/oauth2/v2.0/token"
+	resp, err := p.client.PostForm(u, form)
+	if err != nil {
+		return "", vault.Unavailable(err)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", vault.Unavailable(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("%s: %s", resp.Status, string(b))
+		if vault.UnavailableStatus(resp.StatusCode) {
+			err = vault.Unavailable(err)
+		}
+		return "", err
+	}
+
+	var r struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresIn   json.Number `json:"expires_in"`
+	}
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return "", err
+	}
+	s, _ := r.ExpiresIn.Int64()
+
+	p.cached = r.AccessToken
+	p.expires = time.Now().Add(time.Duration(s) * time.Second)
+
+	return p.cached, nil
+}
+
+var _ vault.Loginer = &KeyVault{}
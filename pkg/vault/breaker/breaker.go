@@ -0,0 +1,311 @@
+// Package breaker provides a backend decorator that limits concurrent requests and stops sending requests to a
+// backend that fails (circuit breaker).
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/mmlt/vault-secret/pkg/vault"
+	"github.com/prometheus/client_golang/prometheus"
+	"net"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// DefaultFailures is the number of consecutive failures that opens the circuit when Config.Failures is not set.
+	DefaultFailures = 5
+	// DefaultOpenTimeout is the time the circuit stays open when Config.OpenTimeout is not set.
+	DefaultOpenTimeout = 30 * time.Second
+	// DefaultProbes is the number of concurrent requests allowed when half-open and Config.Probes is not set.
+	DefaultProbes = 1
+	// DefaultMaxWait is the time a request waits for a free slot when Config.MaxWait is not set.
+	DefaultMaxWait = time.Second
+)
+
+var (
+	// ErrOpen is wrapped by the errors of requests that are rejected because the circuit is open.
+	// It wraps vault.ErrUnavailable so the cache can serve stale values.
+	ErrOpen = fmt.Errorf("circuit open: %w", vault.ErrUnavailable)
+	// ErrLimit is wrapped by the errors of requests that are rejected because too many requests are in flight.
+	// It wraps vault.ErrUnavailable so the cache can serve stale values.
+	ErrLimit = fmt.Errorf("too many requests in flight: %w", vault.ErrUnavailable)
+)
+
+var (
+	circuitState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "vaultsecret_backend_circuit_state",
+		Help: "State of the circuit breaker of a backend (0 closed, 1 half-open, 2 open).",
+	}, []string{"backend"})
+	inFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "vaultsecret_backend_requests_in_flight",
+		Help: "Number of Login and Get requests to a backend that are in flight.",
+	}, []string{"backend"})
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "vaultsecret_backend_requests_total",
+		Help: "Total number of Login and Get requests to a backend by result (success, failure, open or limit).",
+	}, []string{"backend", "result"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(circuitState, inFlight, requestsTotal)
+}
+
+// Config of a circuit breaker.
+type Config struct {
+	// Enabled wraps backends with a circuit breaker.
+	Enabled bool `json:"enabled,omitempty"`
+	// Failures is the number of consecutive failures that opens the circuit, defaults to 5.
+	Failures int `json:"failures,omitempty"`
+	// OpenTimeout is the time the circuit stays open before probe requests are allowed, defaults to 30s.
+	OpenTimeout metav1.Duration `json:"openTimeout,omitempty"`
+	// Probes is the number of concurrent probe requests allowed when half-open, defaults to 1.
+	Probes int `json:"probes,omitempty"`
+	// MaxInFlight is the maximum number of concurrent requests to a backend, 0 is unlimited.
+	MaxInFlight int `json:"maxInFlight,omitempty"`
+	// MaxWait is the time a request waits for a free slot when MaxInFlight is reached, defaults to 1s.
+	MaxWait metav1.Duration `json:"maxWait,omitempty"`
+}
+
+// State of a circuit.
+type state int
+
+const (
+	// Closed lets all requests pass.
+	closed state = iota
+	// HalfOpen lets a limited number of probe requests pass.
+	halfOpen
+	// Open rejects all requests.
+	open
+)
+
+// New returns a backend that passes Login, Get, Put, Decrypt and Wrap requests to l while l is available.
+// After cfg.Failures consecutive failures the circuit opens and requests are rejected without calling l.
+// After cfg.OpenTimeout probe requests are let through, the circuit closes when a probe succeeds.
+// A failure is an error that wraps vault.ErrUnavailable, a network error or a timeout.
+// Name is used in metrics and error messages.
+func New(name string, l vault.Loginer, cfg Config) *Loginer {
+	b := &Loginer{
+		loginer:     l,
+		name:        name,
+		failures:    cfg.Failures,
+		openTimeout: cfg.OpenTimeout.Duration,
+		probes:      cfg.Probes,
+		maxWait:     cfg.MaxWait.Duration,
+		now:         time.Now,
+	}
+	if b.name == "" {
+		b.name = "default"
+	}
+	if b.failures <= 0 {
+		b.failures = DefaultFailures
+	}
+	if b.openTimeout <= 0 {
+		b.openTimeout = DefaultOpenTimeout
+	}
+	if b.probes <= 0 {
+		b.probes = DefaultProbes
+	}
+	if b.maxWait <= 0 {
+		b.maxWait = DefaultMaxWait
+	}
+	if cfg.MaxInFlight > 0 {
+		b.slots = make(chan struct{}, cfg.MaxInFlight)
+	}
+	circuitState.WithLabelValues(b.name).Set(float64(closed))
+	return b
+}
+
+// Decorator returns a vault.Decorator that wraps backends with a circuit breaker, nil when disabled.
+func Decorator(cfg Config) vault.Decorator {
+	if !cfg.Enabled {
+		return nil
+	}
+	return func(name string, l vault.Loginer) vault.Loginer {
+		return New(name, l, cfg)
+	}
+}
+
+// Loginer is a backend with a circuit breaker and a limit on the number of requests in flight.
+type Loginer struct {
+	loginer     vault.Loginer
+	name        string
+	failures    int
+	openTimeout time.Duration
+	probes      int
+	maxWait     time.Duration
+	// slots limits the requests in flight, nil is unlimited.
+	slots chan struct{}
+
+	mu    sync.Mutex
+	state state
+	// failed is the number of consecutive failures.
+	failed int
+	// opened is the time the circuit opened.
+	opened time.Time
+	// probing is the number of probe requests in flight.
+	probing int
+
+	// now is replaced in tests.
+	now func() time.Time
+}
+
+// Login logs in to the backend.
+// The returned Getter implements the same optional interfaces (like vault.Putter) as the Getter of the backend.
+func (b *Loginer) Login(req vault.LoginRequest) (vault.Getter, error) {
+	var g vault.Getter
+	err := b.do(func() error {
+		var err error
+		g, err = b.loginer.Login(req)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	get := func(path string) (map[string]string, error) {
+		var v map[string]string
+		err := b.do(func() error {
+			var err error
+			v, err = g.Get(path)
+			return err
+		})
+		return v, err
+	}
+	put := func(path string, values map[string]string) error {
+		return b.do(func() error {
+			return g.(vault.Putter).Put(path, values)
+		})
+	}
+	decrypt := func(key string, ciphertexts map[string]string) (map[string]string, error) {
+		var v map[string]string
+		err := b.do(func() error {
+			var err error
+			v, err = g.(vault.Decrypter).Decrypt(key, ciphertexts)
+			return err
+		})
+		return v, err
+	}
+	wrap := func(path string, ttl time.Duration) (string, error) {
+		var token string
+		err := b.do(func() error {
+			var err error
+			token, err = g.(vault.Wrapper).Wrap(path, ttl)
+			return err
+		})
+		return token, err
+	}
+	return vault.Decorate(g, get, put, decrypt, wrap), nil
+}
+
+// Do calls fn when the circuit and the in flight limit allow it.
+func (b *Loginer) do(fn func() error) error {
+	probe, err := b.allow()
+	if err != nil {
+		requestsTotal.WithLabelValues(b.name, "open").Inc()
+		return err
+	}
+
+	if b.slots != nil {
+		t := time.NewTimer(b.maxWait)
+		select {
+		case b.slots <- struct{}{}:
+			t.Stop()
+		case <-t.C:
+			b.done(probe, nil)
+			requestsTotal.WithLabelValues(b.name, "limit").Inc()
+			return fmt.Errorf("backend %s: %w (max %d)", b.name, ErrLimit, cap(b.slots))
+		}
+		defer func() { <-b.slots }()
+	}
+
+	inFlight.WithLabelValues(b.name).Inc()
+	err = fn()
+	inFlight.WithLabelValues(b.name).Dec()
+
+	failed := isFailure(err)
+	b.done(probe, &failed)
+	if failed {
+		requestsTotal.WithLabelValues(b.name, "failure").Inc()
+	} else {
+		requestsTotal.WithLabelValues(b.name, "success").Inc()
+	}
+	return err
+}
+
+// Allow returns an error when the circuit is open.
+// Probe is true when the request is a probe of a half-open circuit.
+func (b *Loginer) allow() (probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == open {
+		retry := b.opened.Add(b.openTimeout).Sub(b.now())
+		if retry > 0 {
+			return false, fmt.Errorf("backend %s: %w (%d consecutive failures, retry in %s)",
+				b.name, ErrOpen, b.failed, retry.Round(time.Second))
+		}
+		b.setState(halfOpen)
+	}
+
+	if b.state == halfOpen {
+		if b.probing >= b.probes {
+			return false, fmt.Errorf("backend %s: %w (waiting for probe requests to complete)", b.name, ErrOpen)
+		}
+		b.probing++
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// Done records the result of a request.
+// Failed is nil when the request didn't reach the backend.
+func (b *Loginer) done(probe bool, failed *bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		b.probing--
+	}
+	if failed == nil {
+		return
+	}
+
+	if !*failed {
+		b.failed = 0
+		b.setState(closed)
+		return
+	}
+
+	b.failed++
+	if probe || b.failed >= b.failures {
+		b.opened = b.now()
+		b.setState(open)
+	}
+}
+
+// SetState sets the state of the circuit.
+// Must be called with mu held.
+func (b *Loginer) setState(s state) {
+	b.state = s
+	circuitState.WithLabelValues(b.name).Set(float64(s))
+}
+
+// IsFailure returns true when err shows the backend is unavailable.
+func isFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, vault.ErrUnavailable) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
+
+var _ vault.Loginer = &Loginer{}
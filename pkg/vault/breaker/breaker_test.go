@@ -0,0 +1,200 @@
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mmlt/vault-secret/pkg/vault"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// FakeBackend returns err from Get and Put.
+type fakeBackend struct {
+	mu    sync.Mutex
+	err   error
+	calls int32
+	puts  int32
+	// block (when not nil) delays Get until it's closed.
+	block chan struct{}
+}
+
+func (b *fakeBackend) Login(_ vault.LoginRequest) (vault.Getter, error) {
+	return b, nil
+}
+
+func (b *fakeBackend) Get(path string) (map[string]string, error) {
+	atomic.AddInt32(&b.calls, 1)
+	if b.block != nil {
+		<-b.block
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return map[string]string{"path": path}, b.err
+}
+
+func (b *fakeBackend) Put(path string, values map[string]string) error {
+	atomic.AddInt32(&b.puts, 1)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err
+}
+
+func (b *fakeBackend) setErr(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.err = err
+}
+
+var errDown = fmt.Errorf("%w: connection refused", vault.ErrUnavailable)
+
+// TestBreaker returns a breaker of b with a clock that is advanced by the returned function.
+func testBreaker(b vault.Loginer, cfg Config) (vault.Getter, func(time.Duration)) {
+	l := New("test", b, cfg)
+	now := time.Now()
+	var mu sync.Mutex
+	l.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	g, err := l.Login(vault.LoginRequest{})
+	if err != nil {
+		panic(err)
+	}
+	return g, func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(d)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	t.Run("should_open_after_consecutive_failures", func(t *testing.T) {
+		b := &fakeBackend{err: errDown}
+		g, _ := testBreaker(b, Config{Failures: 3})
+
+		for i := 0; i < 3; i++ {
+			_, err := g.Get("p")
+			assert.True(t, errors.Is(err, vault.ErrUnavailable))
+		}
+		_, err := g.Get("p")
+		assert.True(t, errors.Is(err, ErrOpen))
+		assert.True(t, errors.Is(err, vault.ErrUnavailable))
+		assert.EqualError(t, err, "backend test: circuit open: backend unavailable (3 consecutive failures, retry in 30s)")
+		assert.EqualValues(t, 3, b.calls)
+	})
+
+	t.Run("should_not_count_errors_of_an_available_backend", func(t *testing.T) {
+		b := &fakeBackend{err: errors.New("permission denied")}
+		g, _ := testBreaker(b, Config{Failures: 1})
+
+		for i := 0; i < 3; i++ {
+			_, err := g.Get("p")
+			assert.EqualError(t, err, "permission denied")
+		}
+		assert.EqualValues(t, 3, b.calls)
+	})
+
+	t.Run("should_close_when_probe_succeeds", func(t *testing.T) {
+		b := &fakeBackend{err: errDown}
+		g, advance := testBreaker(b, Config{Failures: 1, OpenTimeout: metav1.Duration{Duration: time.Minute}})
+
+		_, _ = g.Get("p")
+		advance(time.Minute)
+		b.setErr(nil)
+		_, err := g.Get("p")
+		assert.NoError(t, err)
+		_, err = g.Get("p")
+		assert.NoError(t, err)
+		assert.EqualValues(t, 3, b.calls)
+	})
+
+	t.Run("should_reopen_when_probe_fails", func(t *testing.T) {
+		b := &fakeBackend{err: errDown}
+		g, advance := testBreaker(b, Config{Failures: 2, OpenTimeout: metav1.Duration{Duration: time.Minute}})
+
+		_, _ = g.Get("p")
+		_, _ = g.Get("p")
+		advance(time.Minute)
+		_, err := g.Get("p")
+		assert.True(t, errors.Is(err, vault.ErrUnavailable))
+		_, err = g.Get("p")
+		assert.True(t, errors.Is(err, ErrOpen))
+		assert.EqualValues(t, 3, b.calls)
+	})
+
+	t.Run("should_limit_probes", func(t *testing.T) {
+		b := &fakeBackend{err: errDown}
+		g, advance := testBreaker(b, Config{Failures: 1})
+
+		_, _ = g.Get("p")
+		advance(DefaultOpenTimeout)
+		b.setErr(nil)
+		b.block = make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_, _ = g.Get("p")
+		}()
+		// wait for the probe to be in flight.
+		for atomic.LoadInt32(&b.calls) < 2 {
+			time.Sleep(time.Millisecond)
+		}
+		_, err := g.Get("p")
+		assert.True(t, errors.Is(err, ErrOpen))
+
+		close(b.block)
+		<-done
+		_, err = g.Get("p")
+		assert.NoError(t, err)
+	})
+
+	t.Run("should_limit_requests_in_flight", func(t *testing.T) {
+		b := &fakeBackend{block: make(chan struct{})}
+		g, _ := testBreaker(b, Config{MaxInFlight: 1, MaxWait: metav1.Duration{Duration: 10 * time.Millisecond}})
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_, _ = g.Get("p")
+		}()
+		for atomic.LoadInt32(&b.calls) < 1 {
+			time.Sleep(time.Millisecond)
+		}
+		_, err := g.Get("p")
+		assert.True(t, errors.Is(err, vault.ErrUnavailable))
+		assert.EqualError(t, err, "backend test: too many requests in flight: backend unavailable (max 1)")
+
+		close(b.block)
+		<-done
+	})
+
+	t.Run("should_reject_put_when_open", func(t *testing.T) {
+		b := &fakeBackend{err: errDown}
+		g, _ := testBreaker(b, Config{Failures: 2})
+		p, ok := g.(vault.Putter)
+		if !assert.True(t, ok, "should keep vault.Putter") {
+			return
+		}
+
+		_, _ = g.Get("p")
+		err := p.Put("p", map[string]string{"k": "v"})
+		assert.True(t, errors.Is(err, vault.ErrUnavailable))
+		err = p.Put("p", map[string]string{"k": "v"})
+		assert.True(t, errors.Is(err, ErrOpen))
+		assert.EqualValues(t, 1, b.puts)
+	})
+
+	t.Run("should_keep_optional_interfaces", func(t *testing.T) {
+		g, _ := testBreaker(&fakeBackend{}, Config{})
+		_, ok := g.(vault.Putter)
+		assert.True(t, ok)
+		_, ok = g.(vault.Wrapper)
+		assert.False(t, ok)
+	})
+}
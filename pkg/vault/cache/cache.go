@@ -0,0 +1,373 @@
+// Package cache provides a backend decorator that caches the values read from a backend in memory.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/mmlt/vault-secret/pkg/vault"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// DefaultTTL is the time values are cached when Config.TTL is not set.
+	DefaultTTL = 30 * time.Second
+	// DefaultStaleIfError is the time after expiry values are used when the backend is unavailable and
+	// Config.StaleIfError is not set.
+	DefaultStaleIfError = 5 * time.Minute
+)
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "vaultsecret_cache_requests_total",
+		Help: "Total number of cached backend reads by result (hit, miss or stale).",
+	}, []string{"result"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(requestsTotal)
+}
+
+// Config of a cache.
+type Config struct {
+	// Enabled caches values read from backends.
+	Enabled bool `json:"enabled,omitempty"`
+	// TTL is the time values are cached, defaults to 30s.
+	TTL metav1.Duration `json:"ttl,omitempty"`
+	// MountTTLs overrides TTL for paths that start with a mount path, for example "secret/": 5m.
+	// The longest matching mount path wins.
+	MountTTLs map[string]metav1.Duration `json:"mountTTLs,omitempty"`
+	// StaleIfError is the time after expiry values are used when the backend is unavailable, defaults to 5m.
+	// A negative value disables the use of expired values.
+	StaleIfError metav1.Duration `json:"staleIfError,omitempty"`
+}
+
+// New returns a backend that caches the values read from l.
+// Values are cached per auth path, role, namespace, identity (JWT) and path so requesters never see values read with
+// other credentials. Paths that select a version (like .../versions/3) are cached separately.
+// Logins are not cached so credentials are checked on each request, unless the backend is unavailable and the
+// requester has cached values.
+func New(l vault.Loginer, cfg Config) *Loginer {
+	c := &Loginer{
+		loginer:      l,
+		ttl:          cfg.TTL.Duration,
+		mountTTLs:    make(map[string]time.Duration, len(cfg.MountTTLs)),
+		staleIfError: cfg.StaleIfError.Duration,
+		entries:      map[key]*entry{},
+		calls:        map[key]*call{},
+		now:          time.Now,
+	}
+	if c.ttl <= 0 {
+		c.ttl = DefaultTTL
+	}
+	for m, d := range cfg.MountTTLs {
+		c.mountTTLs[m] = d.Duration
+	}
+	switch {
+	case c.staleIfError == 0:
+		c.staleIfError = DefaultStaleIfError
+	case c.staleIfError < 0:
+		c.staleIfError = 0
+	}
+	return c
+}
+
+// Decorator returns a vault.Decorator that wraps backends with a cache, nil when caching is disabled.
+func Decorator(cfg Config) vault.Decorator {
+	if !cfg.Enabled {
+		return nil
+	}
+	return func(_ string, l vault.Loginer) vault.Loginer {
+		return New(l, cfg)
+	}
+}
+
+// Loginer is a backend that caches the values read from another backend.
+type Loginer struct {
+	loginer      vault.Loginer
+	ttl          time.Duration
+	mountTTLs    map[string]time.Duration
+	staleIfError time.Duration
+
+	mu      sync.Mutex
+	entries map[key]*entry
+	// calls are the reads in progress.
+	calls map[key]*call
+	// swept is the time expired entries were last removed.
+	swept time.Time
+	// generation is incremented on each invalidation.
+	generation int
+
+	// now is replaced in tests.
+	now func() time.Time
+}
+
+// Key identifies cached values.
+type key struct {
+	authPath, role, namespace, path string
+	// identity is a hash of the JWT the values are read with, empty when read with the identity of vault-secret.
+	identity string
+}
+
+// Entry is a cached value.
+// Values are kept as byte slices so they can be zeroed on eviction.
+type entry struct {
+	values  map[string][]byte
+	expires time.Time
+}
+
+// Call is a read in progress, concurrent reads of the same key wait for it to complete.
+type call struct {
+	wg     sync.WaitGroup
+	values map[string]string
+	err    error
+}
+
+// Login logs in to the backend and returns a Getter that reads via the cache.
+// The returned Getter implements the same optional interfaces (like vault.Putter) as the Getter of the backend,
+// Put invalidates the cached values of the path.
+// When the backend is unavailable the returned Getter only returns the cached values of the requester that can be
+// used (see Config.StaleIfError), other requests return the login error.
+func (c *Loginer) Login(req vault.LoginRequest) (vault.Getter, error) {
+	id := identity(req.JWT)
+	k := func(path string) key {
+		return key{authPath: req.AuthPath, role: req.Role, namespace: req.Namespace, identity: id, path: path}
+	}
+
+	g, err := c.loginer.Login(req)
+	if errors.Is(err, vault.ErrUnavailable) && c.staleIfError > 0 {
+		return &staleGetter{cache: c, key: k, err: err}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	get := func(path string) (map[string]string, error) {
+		return c.get(g, k(path))
+	}
+	put := func(path string, values map[string]string) error {
+		err := g.(vault.Putter).Put(path, values)
+		c.Invalidate(path)
+		return err
+	}
+	return vault.Decorate(g, get, put, nil, nil), nil
+}
+
+// Invalidate removes the cached values of path for all requesters.
+func (c *Loginer) Invalidate(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for k, e := range c.entries {
+		if k.path == path {
+			c.evict(k, e)
+		}
+	}
+}
+
+// Close removes all cached values.
+// The backend itself isn't closed, it's owned by the caller of New.
+func (c *Loginer) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, e := range c.entries {
+		c.evict(k, e)
+	}
+	return nil
+}
+
+// Get returns the values of k, reading them with g when they're not cached or expired.
+func (c *Loginer) get(g vault.Getter, k key) (map[string]string, error) {
+	now := c.now()
+
+	c.mu.Lock()
+	c.sweep(now)
+	if e, ok := c.entries[k]; ok && now.Before(e.expires) {
+		v := e.strings()
+		c.mu.Unlock()
+		requestsTotal.WithLabelValues("hit").Inc()
+		return v, nil
+	}
+	if cl, ok := c.calls[k]; ok {
+		c.mu.Unlock()
+		cl.wg.Wait()
+		return copyMap(cl.values), cl.err
+	}
+	cl := &call{}
+	cl.wg.Add(1)
+	c.calls[k] = cl
+	gen := c.generation
+	c.mu.Unlock()
+
+	cl.values, cl.err = g.Get(k.path)
+
+	c.mu.Lock()
+	delete(c.calls, k)
+	e, cached := c.entries[k]
+	switch {
+	case cl.err == nil:
+		// don't cache values that might have been read before an invalidation.
+		if gen == c.generation {
+			if cached {
+				c.evict(k, e)
+			}
+			c.entries[k] = newEntry(cl.values, c.now().Add(c.ttlOf(k.path)))
+		}
+		requestsTotal.WithLabelValues("miss").Inc()
+	case cached && now.Before(e.expires.Add(c.staleIfError)) && errors.Is(cl.err, vault.ErrUnavailable):
+		// the backend is unavailable, use the last known values.
+		cl.values, cl.err = e.strings(), nil
+		requestsTotal.WithLabelValues("stale").Inc()
+	default:
+		requestsTotal.WithLabelValues("miss").Inc()
+	}
+	c.mu.Unlock()
+	cl.wg.Done()
+
+	return copyMap(cl.values), cl.err
+}
+
+// Stale returns the values of k when they're not older than staleIfError after expiry.
+func (c *Loginer) stale(k key) (map[string]string, bool) {
+	now := c.now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[k]
+	if !ok || !now.Before(e.expires.Add(c.staleIfError)) {
+		return nil, false
+	}
+	if now.Before(e.expires) {
+		requestsTotal.WithLabelValues("hit").Inc()
+	} else {
+		requestsTotal.WithLabelValues("stale").Inc()
+	}
+	return e.strings(), true
+}
+
+// StaleGetter returns cached values when the backend is unavailable at login.
+// It implements the optional interfaces of a Getter so requests that need the backend fail with the login error
+// instead of being reported as unsupported.
+type staleGetter struct {
+	cache *Loginer
+	key   func(path string) key
+	// err is the login error.
+	err error
+}
+
+// Get returns the cached values of path or the login error.
+func (g *staleGetter) Get(path string) (map[string]string, error) {
+	v, ok := g.cache.stale(g.key(path))
+	if !ok {
+		requestsTotal.WithLabelValues("miss").Inc()
+		return nil, g.err
+	}
+	return v, nil
+}
+
+// Put returns the login error.
+func (g *staleGetter) Put(_ string, _ map[string]string) error {
+	return g.err
+}
+
+// Decrypt returns the login error.
+func (g *staleGetter) Decrypt(_ string, _ map[string]string) (map[string]string, error) {
+	return nil, g.err
+}
+
+// Wrap returns the login error.
+func (g *staleGetter) Wrap(_ string, _ time.Duration) (string, error) {
+	return "", g.err
+}
+
+// Identity returns a hash of jwt so tokens aren't kept in memory longer than needed.
+func identity(jwt string) string {
+	if jwt == "" {
+		return ""
+	}
+	h := sha256.Sum256([]byte(jwt))
+	return hex.EncodeToString(h[:])
+}
+
+// TtlOf returns the TTL of path.
+func (c *Loginer) ttlOf(path string) time.Duration {
+	ttl, n := c.ttl, 0
+	for m, d := range c.mountTTLs {
+		if strings.HasPrefix(path, m) && len(m) > n {
+			ttl, n = d, len(m)
+		}
+	}
+	return ttl
+}
+
+// Sweep evicts the entries that can no longer be used.
+// Sweeping is done at most once per TTL.
+// Must be called with mu held.
+func (c *Loginer) sweep(now time.Time) {
+	if now.Sub(c.swept) < c.ttl {
+		return
+	}
+	c.swept = now
+	for k, e := range c.entries {
+		if !now.Before(e.expires.Add(c.staleIfError)) {
+			c.evict(k, e)
+		}
+	}
+}
+
+// Evict removes an entry and zeroes its values.
+// Must be called with mu held.
+func (c *Loginer) evict(k key, e *entry) {
+	for _, b := range e.values {
+		for i := range b {
+			b[i] = 0
+		}
+	}
+	e.values = nil
+	delete(c.entries, k)
+}
+
+func newEntry(values map[string]string, expires time.Time) *entry {
+	e := &entry{
+		values:  make(map[string][]byte, len(values)),
+		expires: expires,
+	}
+	for k, v := range values {
+		e.values[k] = []byte(v)
+	}
+	return e
+}
+
+// Strings returns a copy of the values.
+func (e *entry) strings() map[string]string {
+	r := make(map[string]string, len(e.values))
+	for k, v := range e.values {
+		r[k] = string(v)
+	}
+	return r
+}
+
+func copyMap(in map[string]string) map[string]string {
+	if in == nil {
+		return nil
+	}
+	r := make(map[string]string, len(in))
+	for k, v := range in {
+		r[k] = v
+	}
+	return r
+}
+
+var _ vault.Loginer = &Loginer{}
+var _ vault.Putter = &staleGetter{}
+var _ vault.Decrypter = &staleGetter{}
+var _ vault.Wrapper = &staleGetter{}
+var _ vault.Invalidator = &Loginer{}
+var _ io.Closer = &Loginer{}
@@ -0,0 +1,352 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mmlt/vault-secret/pkg/vault"
+	"github.com/mmlt/vault-secret/pkg/vault/breaker"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// FakeBackend counts reads, values are "<role>:<namespace>:<path>" with ":<jwt>" appended when a JWT is used.
+type fakeBackend struct {
+	reads  int32
+	logins int32
+	err    error
+	// loginErr (when not nil) is returned by Login.
+	loginErr error
+	// block (when not nil) delays reads until it's closed.
+	block chan struct{}
+}
+
+func (b *fakeBackend) Login(req vault.LoginRequest) (vault.Getter, error) {
+	atomic.AddInt32(&b.logins, 1)
+	if b.loginErr != nil {
+		return nil, b.loginErr
+	}
+	return &fakeGetter{backend: b, req: req}, nil
+}
+
+type fakeGetter struct {
+	backend *fakeBackend
+	req     vault.LoginRequest
+}
+
+func (g *fakeGetter) Get(path string) (map[string]string, error) {
+	atomic.AddInt32(&g.backend.reads, 1)
+	if g.backend.block != nil {
+		<-g.backend.block
+	}
+	if g.backend.err != nil {
+		return nil, g.backend.err
+	}
+	v := g.req.Role + ":" + g.req.Namespace + ":" + path
+	if g.req.JWT != "" {
+		v += ":" + g.req.JWT
+	}
+	return map[string]string{"v": v}, nil
+}
+
+// FakePutGetter is a Getter that can write.
+type fakePutGetter struct {
+	*fakeGetter
+}
+
+func (g fakePutGetter) Put(path string, values map[string]string) error {
+	return nil
+}
+
+type fakePutBackend struct {
+	fakeBackend
+}
+
+func (b *fakePutBackend) Login(req vault.LoginRequest) (vault.Getter, error) {
+	return fakePutGetter{&fakeGetter{backend: &b.fakeBackend, req: req}}, nil
+}
+
+// TestCache returns a cache of b with a clock that is advanced by the returned function.
+func testCache(b vault.Loginer, cfg Config) (*Loginer, func(time.Duration)) {
+	c := New(b, cfg)
+	now := time.Now()
+	var mu sync.Mutex
+	c.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	return c, func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(d)
+	}
+}
+
+func get(t *testing.T, c *Loginer, role, namespace, path string) (string, error) {
+	g, err := c.Login(vault.LoginRequest{Role: role, Namespace: namespace})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := g.Get(path)
+	return v["v"], err
+}
+
+func TestCache(t *testing.T) {
+	t.Run("should_cache_until_ttl_expires", func(t *testing.T) {
+		b := &fakeBackend{}
+		c, advance := testCache(b, Config{TTL: metav1.Duration{Duration: time.Minute}})
+
+		v, err := get(t, c, "r", "ns", "secret/app")
+		assert.NoError(t, err)
+		assert.Equal(t, "r:ns:secret/app", v)
+		_, _ = get(t, c, "r", "ns", "secret/app")
+		assert.EqualValues(t, 1, b.reads)
+
+		advance(time.Minute)
+		_, _ = get(t, c, "r", "ns", "secret/app")
+		assert.EqualValues(t, 2, b.reads)
+	})
+
+	t.Run("should_cache_per_role_and_namespace", func(t *testing.T) {
+		b := &fakeBackend{}
+		c, _ := testCache(b, Config{})
+
+		v1, _ := get(t, c, "r1", "ns", "secret/app")
+		v2, _ := get(t, c, "r2", "ns", "secret/app")
+		v3, _ := get(t, c, "r1", "other", "secret/app")
+		assert.Equal(t, []string{"r1:ns:secret/app", "r2:ns:secret/app", "r1:other:secret/app"}, []string{v1, v2, v3})
+		assert.EqualValues(t, 3, b.reads)
+	})
+
+	t.Run("should_cache_per_identity", func(t *testing.T) {
+		b := &fakeBackend{}
+		c, _ := testCache(b, Config{})
+
+		var got []string
+		for _, jwt := range []string{"jwt-a", "jwt-b", "jwt-a"} {
+			g, err := c.Login(vault.LoginRequest{Role: "r", Namespace: "ns", JWT: jwt})
+			if err != nil {
+				t.Fatal(err)
+			}
+			v, err := g.Get("secret/app")
+			assert.NoError(t, err)
+			got = append(got, v["v"])
+		}
+		assert.Equal(t, []string{"r:ns:secret/app:jwt-a", "r:ns:secret/app:jwt-b", "r:ns:secret/app:jwt-a"}, got)
+		assert.EqualValues(t, 2, b.reads)
+	})
+
+	t.Run("should_use_longest_mount_ttl", func(t *testing.T) {
+		b := &fakeBackend{}
+		c, advance := testCache(b, Config{
+			TTL: metav1.Duration{Duration: time.Minute},
+			MountTTLs: map[string]metav1.Duration{
+				"static/":      {Duration: time.Hour},
+				"static/fast/": {Duration: time.Second},
+			},
+		})
+
+		_, _ = get(t, c, "r", "ns", "static/app")
+		_, _ = get(t, c, "r", "ns", "static/fast/app")
+		advance(time.Minute)
+		_, _ = get(t, c, "r", "ns", "static/app")
+		_, _ = get(t, c, "r", "ns", "static/fast/app")
+		assert.EqualValues(t, 3, b.reads)
+	})
+
+	t.Run("should_read_once_for_concurrent_requests", func(t *testing.T) {
+		b := &fakeBackend{block: make(chan struct{})}
+		c, _ := testCache(b, Config{})
+
+		var wg sync.WaitGroup
+		got := make([]string, 10)
+		for i := range got {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				got[i], _ = get(t, c, "r", "ns", "secret/app")
+			}(i)
+		}
+		// let the requests queue up.
+		time.Sleep(100 * time.Millisecond)
+		close(b.block)
+		wg.Wait()
+
+		assert.EqualValues(t, 1, b.reads)
+		for _, v := range got {
+			assert.Equal(t, "r:ns:secret/app", v)
+		}
+	})
+
+	t.Run("should_return_stale_values_when_unavailable", func(t *testing.T) {
+		b := &fakeBackend{}
+		c, advance := testCache(b, Config{
+			TTL:          metav1.Duration{Duration: time.Minute},
+			StaleIfError: metav1.Duration{Duration: time.Minute},
+		})
+
+		_, _ = get(t, c, "r", "ns", "secret/app")
+		b.err = fmt.Errorf("vault down: %w", vault.ErrUnavailable)
+
+		advance(90 * time.Second)
+		v, err := get(t, c, "r", "ns", "secret/app")
+		assert.NoError(t, err)
+		assert.Equal(t, "r:ns:secret/app", v)
+
+		advance(time.Minute)
+		_, err = get(t, c, "r", "ns", "secret/app")
+		assert.True(t, errors.Is(err, vault.ErrUnavailable), "got %v", err)
+	})
+
+	t.Run("should_return_stale_values_when_login_fails", func(t *testing.T) {
+		b := &fakeBackend{}
+		c, advance := testCache(b, Config{
+			TTL:          metav1.Duration{Duration: time.Minute},
+			StaleIfError: metav1.Duration{Duration: time.Minute},
+		})
+
+		_, _ = get(t, c, "r", "ns", "secret/app")
+		b.loginErr = fmt.Errorf("vault down: %w", vault.ErrUnavailable)
+
+		advance(90 * time.Second)
+		v, err := get(t, c, "r", "ns", "secret/app")
+		assert.NoError(t, err)
+		assert.Equal(t, "r:ns:secret/app", v)
+		assert.EqualValues(t, 1, b.reads)
+
+		_, err = get(t, c, "other", "ns", "secret/app")
+		assert.True(t, errors.Is(err, vault.ErrUnavailable), "other requester got %v", err)
+		_, err = get(t, c, "r", "ns", "secret/other")
+		assert.True(t, errors.Is(err, vault.ErrUnavailable), "other path got %v", err)
+
+		g, err := c.Login(vault.LoginRequest{Role: "r", Namespace: "ns"})
+		if !assert.NoError(t, err) {
+			return
+		}
+		_, err = g.(vault.Decrypter).Decrypt("key", map[string]string{"f": "vault:v1:x"})
+		assert.True(t, errors.Is(err, vault.ErrUnavailable), "decrypt got %v", err)
+
+		advance(time.Minute)
+		_, err = get(t, c, "r", "ns", "secret/app")
+		assert.True(t, errors.Is(err, vault.ErrUnavailable), "got %v", err)
+	})
+
+	t.Run("should_return_login_errors_when_available", func(t *testing.T) {
+		b := &fakeBackend{}
+		c, _ := testCache(b, Config{})
+
+		_, _ = get(t, c, "r", "ns", "secret/app")
+		b.loginErr = errors.New("Code: 403. Errors: permission denied")
+		_, err := c.Login(vault.LoginRequest{Role: "r", Namespace: "ns"})
+		assert.EqualError(t, err, "Code: 403. Errors: permission denied")
+	})
+
+	t.Run("should_not_return_stale_values_when_disabled", func(t *testing.T) {
+		b := &fakeBackend{}
+		c, advance := testCache(b, Config{StaleIfError: metav1.Duration{Duration: -1}})
+
+		_, _ = get(t, c, "r", "ns", "secret/app")
+		b.err = fmt.Errorf("vault down: %w", vault.ErrUnavailable)
+		advance(DefaultTTL)
+		_, err := get(t, c, "r", "ns", "secret/app")
+		assert.True(t, errors.Is(err, vault.ErrUnavailable), "got %v", err)
+	})
+
+	t.Run("should_not_return_stale_values_on_other_errors", func(t *testing.T) {
+		b := &fakeBackend{}
+		c, advance := testCache(b, Config{})
+
+		_, _ = get(t, c, "r", "ns", "secret/app")
+		b.err = errors.New("Code: 403. Errors: permission denied")
+		advance(DefaultTTL)
+		_, err := get(t, c, "r", "ns", "secret/app")
+		assert.EqualError(t, err, "Code: 403. Errors: permission denied")
+	})
+
+	t.Run("should_read_after_invalidate", func(t *testing.T) {
+		b := &fakeBackend{}
+		c, _ := testCache(b, Config{})
+
+		_, _ = get(t, c, "r", "ns", "secret/app")
+		c.Invalidate("secret/app")
+		_, _ = get(t, c, "r", "ns", "secret/app")
+		assert.EqualValues(t, 2, b.reads)
+	})
+
+	t.Run("should_invalidate_on_put", func(t *testing.T) {
+		b := &fakePutBackend{}
+		c, _ := testCache(b, Config{})
+
+		g, err := c.Login(vault.LoginRequest{Role: "r"})
+		assert.NoError(t, err)
+		_, _ = g.Get("secret/app")
+		p, ok := g.(vault.Putter)
+		if !assert.True(t, ok, "should keep vault.Putter") {
+			return
+		}
+		assert.NoError(t, p.Put("secret/app", map[string]string{"v": "x"}))
+		_, _ = g.Get("secret/app")
+		assert.EqualValues(t, 2, b.reads)
+	})
+
+	t.Run("should_not_add_optional_interfaces", func(t *testing.T) {
+		c, _ := testCache(&fakeBackend{}, Config{})
+		g, err := c.Login(vault.LoginRequest{})
+		assert.NoError(t, err)
+		_, ok := g.(vault.Putter)
+		assert.False(t, ok)
+	})
+
+	t.Run("should_zero_values_on_eviction", func(t *testing.T) {
+		c, advance := testCache(&fakeBackend{}, Config{StaleIfError: metav1.Duration{Duration: -1}})
+
+		_, _ = get(t, c, "r", "ns", "secret/app")
+		var b []byte
+		for _, e := range c.entries {
+			b = e.values["v"]
+		}
+		assert.Equal(t, "r:ns:secret/app", string(b))
+
+		advance(DefaultTTL)
+		_, _ = get(t, c, "r", "ns", "other")
+		assert.Equal(t, make([]byte, len(b)), b)
+	})
+}
+
+func TestCacheOverBreaker(t *testing.T) {
+	b := &fakeBackend{}
+	cb := breaker.New("test", b, breaker.Config{Failures: 1, OpenTimeout: metav1.Duration{Duration: time.Hour}})
+	c, advance := testCache(cb, Config{
+		TTL:          metav1.Duration{Duration: time.Minute},
+		StaleIfError: metav1.Duration{Duration: time.Minute},
+	})
+
+	_, _ = get(t, c, "r", "ns", "secret/app")
+	b.err = fmt.Errorf("vault down: %w", vault.ErrUnavailable)
+	advance(90 * time.Second)
+
+	t.Run("should_return_stale_value_when_read_fails", func(t *testing.T) {
+		v, err := get(t, c, "r", "ns", "secret/app")
+		assert.NoError(t, err)
+		assert.Equal(t, "r:ns:secret/app", v)
+	})
+
+	t.Run("should_return_stale_value_when_circuit_is_open", func(t *testing.T) {
+		logins, reads := b.logins, b.reads
+		v, err := get(t, c, "r", "ns", "secret/app")
+		assert.NoError(t, err)
+		assert.Equal(t, "r:ns:secret/app", v)
+		assert.Equal(t, logins, b.logins, "login is rejected by the circuit breaker")
+		assert.Equal(t, reads, b.reads)
+	})
+
+	t.Run("should_return_circuit_open_without_cached_value", func(t *testing.T) {
+		_, err := get(t, c, "r", "ns", "secret/other")
+		assert.True(t, errors.Is(err, breaker.ErrOpen), "got %v", err)
+	})
+}
@@ -0,0 +1,102 @@
+package vault
+
+import "time"
+
+// Decorator wraps a backend, for example to add caching.
+// Name is the name of the backend, empty for the default backend.
+type Decorator func(name string, l Loginer) Loginer
+
+// GetterFunc adapts a function to a Getter.
+type GetterFunc func(path string) (map[string]string, error)
+
+// Get calls f(path).
+func (f GetterFunc) Get(path string) (map[string]string, error) {
+	return f(path)
+}
+
+// PutterFunc adapts a function to a Putter.
+type PutterFunc func(path string, values map[string]string) error
+
+// Put calls f(path, values).
+func (f PutterFunc) Put(path string, values map[string]string) error {
+	return f(path, values)
+}
+
+// DecrypterFunc adapts a function to a Decrypter.
+type DecrypterFunc func(key string, ciphertexts map[string]string) (map[string]string, error)
+
+// Decrypt calls f(key, ciphertexts).
+func (f DecrypterFunc) Decrypt(key string, ciphertexts map[string]string) (map[string]string, error) {
+	return f(key, ciphertexts)
+}
+
+// WrapperFunc adapts a function to a Wrapper.
+type WrapperFunc func(path string, ttl time.Duration) (string, error)
+
+// Wrap calls f(path, ttl).
+func (f WrapperFunc) Wrap(path string, ttl time.Duration) (string, error) {
+	return f(path, ttl)
+}
+
+// Decorate returns a Getter that calls get instead of the Get method of g.
+// Put, decrypt and wrap (when not nil) are called instead of the Put, Decrypt and Wrap methods of g.
+// The returned Getter implements the same optional interfaces (Putter, Decrypter, Wrapper) as g.
+func Decorate(g Getter, get GetterFunc, put PutterFunc, decrypt DecrypterFunc, wrap WrapperFunc) Getter {
+	p, isPutter := g.(Putter)
+	if isPutter && put != nil {
+		p = put
+	}
+	d, isDecrypter := g.(Decrypter)
+	if isDecrypter && decrypt != nil {
+		d = decrypt
+	}
+	w, isWrapper := g.(Wrapper)
+	if isWrapper && wrap != nil {
+		w = wrap
+	}
+
+	switch {
+	case isPutter && isDecrypter && isWrapper:
+		return struct {
+			GetterFunc
+			Putter
+			Decrypter
+			Wrapper
+		}{get, p, d, w}
+	case isPutter && isDecrypter:
+		return struct {
+			GetterFunc
+			Putter
+			Decrypter
+		}{get, p, d}
+	case isPutter && isWrapper:
+		return struct {
+			GetterFunc
+			Putter
+			Wrapper
+		}{get, p, w}
+	case isPutter:
+		return struct {
+			GetterFunc
+			Putter
+		}{get, p}
+	case isDecrypter && isWrapper:
+		return struct {
+			GetterFunc
+			Decrypter
+			Wrapper
+		}{get, d, w}
+	case isDecrypter:
+		return struct {
+			GetterFunc
+			Decrypter
+		}{get, d}
+	case isWrapper:
+		return struct {
+			GetterFunc
+			Wrapper
+		}{get, w}
+	default:
+		return get
+	}
+}
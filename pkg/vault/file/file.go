@@ -0,0 +1,316 @@
+// Package file provides a backend that reads secrets from a directory tree.
+// It's intended for development, CI and air-gapped clusters without a HashiCorp Vault.
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"github.com/mmlt/vault-secret/pkg/vault"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"sigs.k8s.io/yaml"
+)
+
+func init() {
+	vault.Register("file", factory)
+}
+
+// Factory creates a backend from options:
+// - dir is the directory containing the secret files.
+// - watch "false" disables caching and hot reload of files.
+func factory(options map[string]string) (vault.Loginer, error) {
+	if options["dir"] == "" {
+		return nil, fmt.Errorf("dir is required")
+	}
+
+	watch := true
+	if s := options["watch"]; s != "" {
+		var err error
+		watch, err = strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("watch: %w", err)
+		}
+	}
+
+	if !watch {
+		return New(options["dir"])
+	}
+	return NewWatched(options["dir"])
+}
+
+// New returns a backend that reads secrets from dir.
+// The secret at path "path/to/secret" is read from the first of:
+// - dir/path/to/secret.json containing a JSON object.
+// - dir/path/to/secret.yaml containing a YAML mapping (also .yml).
+// - dir/path/to/secret.env containing KEY=value lines.
+// - dir/path/to/secret/ containing one file per key, the file content is the value.
+// The last layout is compatible with Secrets and ConfigMaps mounted as a volume.
+func New(dir string) (*Backend, error) {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("not a directory: %s", dir)
+	}
+	return &Backend{dir: dir}, nil
+}
+
+// NewWatched returns a backend like New that caches the secrets it reads.
+// The cache is flushed when a file in dir changes.
+// Call Close to stop watching.
+func NewWatched(dir string) (*Backend, error) {
+	b, err := New(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	b.watcher = w
+	b.cache = map[string]map[string]string{}
+
+	err = b.watchTree(b.dir)
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	b.done = make(chan struct{})
+	go b.watch()
+
+	return b, nil
+}
+
+// Backend reads secrets from files.
+type Backend struct {
+	dir string
+
+	// watcher is nil when hot reload is disabled.
+	watcher *fsnotify.Watcher
+	done    chan struct{}
+
+	mu    sync.Mutex
+	cache map[string]map[string]string
+	// generation is incremented on each cache flush.
+	generation int
+}
+
+// Login returns the backend itself, files are not access controlled.
+func (b *Backend) Login(_ vault.LoginRequest) (vault.Getter, error) {
+	return b, nil
+}
+
+// Get values from the file(s) for path.
+func (b *Backend) Get(path string) (map[string]string, error) {
+	var gen int
+	if b.watcher != nil {
+		b.mu.Lock()
+		v, ok := b.cache[path]
+		gen = b.generation
+		b.mu.Unlock()
+		if ok {
+			return copyMap(v), nil
+		}
+	}
+
+	v, err := b.read(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.watcher != nil {
+		b.mu.Lock()
+		// don't cache values that might have been read before a flush.
+		if gen == b.generation {
+			b.cache[path] = v
+		}
+		b.mu.Unlock()
+	}
+
+	return copyMap(v), nil
+}
+
+// Close stops watching for changes.
+func (b *Backend) Close() error {
+	if b.watcher == nil {
+		return nil
+	}
+	err := b.watcher.Close()
+	<-b.done
+	return err
+}
+
+// Read values from the file(s) for path.
+func (b *Backend) read(path string) (map[string]string, error) {
+	f, err := b.filename(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ext := range []string{".json", ".yaml", ".yml", ".env"} {
+		buf, err := ioutil.ReadFile(f + ext)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, vault.UnavailableFile(err)
+		}
+
+		var v map[string]string
+		switch ext {
+		case ".json":
+			v, err = vault.ParseJSON(buf)
+		case ".yaml", ".yml":
+			v, err = parseYAML(buf)
+		case ".env":
+			v, err = parseEnv(buf)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("path %s: %w", path, err)
+		}
+		return v, nil
+	}
+
+	fi, err := os.Stat(f)
+	if err == nil && fi.IsDir() {
+		return readKeys(f)
+	}
+
+	return nil, fmt.Errorf("path not found: %s", path)
+}
+
+// Filename returns the name of the file (without extension) for path.
+// Paths that resolve to a file outside the backend directory are rejected.
+func (b *Backend) filename(path string) (string, error) {
+	f := filepath.Join(b.dir, filepath.FromSlash(path))
+	if !strings.HasPrefix(f, filepath.Clean(b.dir)+string(filepath.Separator)) {
+		return "", fmt.Errorf("path outside of backend: %s", path)
+	}
+	return f, nil
+}
+
+// Watch flushes the cache on file system changes until the watcher is closed.
+func (b *Backend) watch() {
+	defer close(b.done)
+	for {
+		select {
+		case ev, ok := <-b.watcher.Events:
+			if !ok {
+				return
+			}
+			if ev.Op&fsnotify.Create != 0 {
+				// new directories need to be watched too.
+				if fi, err := os.Stat(ev.Name); err == nil && fi.IsDir() {
+					_ = b.watchTree(ev.Name)
+				}
+			}
+			b.flush()
+		case _, ok := <-b.watcher.Errors:
+			if !ok {
+				return
+			}
+			// be safe, flush cache when events might have been missed.
+			b.flush()
+		}
+	}
+}
+
+// Flush empties the cache.
+func (b *Backend) flush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.cache = map[string]map[string]string{}
+	b.generation++
+}
+
+// WatchTree adds root and its subdirectories to the watcher.
+func (b *Backend) watchTree(root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		return b.watcher.Add(path)
+	})
+}
+
+// ReadKeys returns the content of the files in dir by file name.
+// Hidden files (like the ..data symlink of mounted volumes) and directories are skipped.
+func readKeys(dir string) (map[string]string, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, vault.UnavailableFile(err)
+	}
+	r := make(map[string]string, len(fis))
+	for _, fi := range fis {
+		if strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		// follow symlinks
+		fi, err := os.Stat(filepath.Join(dir, fi.Name()))
+		if err != nil || fi.IsDir() {
+			continue
+		}
+		buf, err := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return nil, vault.UnavailableFile(err)
+		}
+		r[fi.Name()] = string(buf)
+	}
+	return r, nil
+}
+
+// ParseYAML parses a YAML mapping.
+func parseYAML(buf []byte) (map[string]string, error) {
+	js, err := yaml.YAMLToJSON(buf)
+	if err != nil {
+		return nil, err
+	}
+	return vault.ParseJSON(js)
+}
+
+// ParseEnv parses KEY=value lines.
+// Empty lines and lines starting with # are skipped, an "export " prefix and quotes around values are removed.
+func parseEnv(buf []byte) (map[string]string, error) {
+	r := map[string]string{}
+	s := bufio.NewScanner(bytes.NewReader(buf))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("line %d: expected KEY=value", n)
+		}
+		k, v := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+			v = v[1 : len(v)-1]
+		}
+		r[k] = v
+	}
+	return r, s.Err()
+}
+
+func copyMap(in map[string]string) map[string]string {
+	r := make(map[string]string, len(in))
+	for k, v := range in {
+		r[k] = v
+	}
+	return r
+}
+
+var _ vault.Loginer = &Backend{}
@@ -0,0 +1,170 @@
+package file
+
+import (
+	"github.com/mmlt/vault-secret/pkg/vault"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	dir := testDir(t, map[string]string{
+		"path/to/secret.json": `{"one": "first-value", "two": 2}`,
+	})
+	defer os.RemoveAll(dir)
+
+	backends := vault.NewBackends(nil)
+	err := backends.Replace(nil, []vault.BackendConfig{
+		{Name: "files", Type: "file", Options: map[string]string{"dir": dir}},
+	})
+	assert.NoError(t, err)
+
+	b, err := backends.Get("files")
+	assert.NoError(t, err)
+	c, err := b.Login(vault.LoginRequest{})
+	assert.NoError(t, err)
+
+	t.Run("should_get_values", func(t *testing.T) {
+		got, err := c.Get("path/to/secret")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"one": "first-value", "two": "2"}, got)
+	})
+
+	t.Run("should_error_on_missing_path", func(t *testing.T) {
+		_, err := c.Get("path/to/missing")
+		assert.Error(t, err)
+	})
+
+	t.Run("should_reject_path_outside_dir", func(t *testing.T) {
+		_, err := c.Get("../secret")
+		assert.Error(t, err)
+	})
+
+	t.Run("should_error_on_unknown_backend", func(t *testing.T) {
+		_, err := backends.Get("unknown")
+		assert.Error(t, err)
+		_, err = backends.Get("")
+		assert.Error(t, err)
+	})
+
+	t.Run("should_error_on_unknown_type", func(t *testing.T) {
+		err := backends.Replace(nil, []vault.BackendConfig{{Name: "x", Type: "unknown"}})
+		assert.Error(t, err)
+		_, err = backends.Get("files")
+		assert.NoError(t, err, "set is unchanged")
+	})
+
+	t.Run("should_replace_on_commit_only", func(t *testing.T) {
+		s, err := backends.Stage(nil, []vault.BackendConfig{
+			{Name: "other", Type: "file", Options: map[string]string{"dir": dir}},
+		}, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		s.Discard()
+		_, err = backends.Get("other")
+		assert.Error(t, err)
+
+		s, err = backends.Stage(nil, []vault.BackendConfig{
+			{Name: "other", Type: "file", Options: map[string]string{"dir": dir}},
+		}, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		s.Commit()
+		_, err = backends.Get("other")
+		assert.NoError(t, err)
+		_, err = backends.Get("files")
+		assert.Error(t, err)
+	})
+}
+
+func TestFormats(t *testing.T) {
+	dir := testDir(t, map[string]string{
+		"json.json":     `{"one": "first-value", "big": 1000000}`,
+		"yaml.yaml":     "one: first-value\nbig: 1000000\n",
+		"env.env":       "# comment\nexport one=\"first-value\"\n\nbig=1000000\n",
+		"keys/one":      "first-value",
+		"keys/big":      "1000000",
+		"keys/.hidden":  "skipped",
+		"invalid.env":   "no-equal-sign",
+		"invalid.json":  `["not", "an", "object"]`,
+		"nested/a.yaml": "one: first-value\nbig: 1000000\n",
+	})
+	defer os.RemoveAll(dir)
+
+	b, err := New(dir)
+	assert.NoError(t, err)
+
+	want := map[string]string{"one": "first-value", "big": "1000000"}
+	for _, p := range []string{"json", "yaml", "env", "keys", "nested/a"} {
+		got, err := b.Get(p)
+		assert.NoError(t, err, p)
+		assert.Equal(t, want, got, p)
+	}
+
+	for _, p := range []string{"invalid", "missing"} {
+		_, err := b.Get(p)
+		assert.Error(t, err, p)
+	}
+}
+
+func TestWatched(t *testing.T) {
+	dir := testDir(t, map[string]string{
+		"secret.json": `{"one": "first-value"}`,
+	})
+	defer os.RemoveAll(dir)
+
+	b, err := NewWatched(dir)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer b.Close()
+
+	got, err := b.Get("secret")
+	assert.NoError(t, err)
+	assert.Equal(t, "first-value", got["one"])
+
+	t.Run("should_reload_changed_file", func(t *testing.T) {
+		err := ioutil.WriteFile(filepath.Join(dir, "secret.json"), []byte(`{"one": "changed-value"}`), 0600)
+		assert.NoError(t, err)
+		assert.Eventually(t, func() bool {
+			got, err := b.Get("secret")
+			return err == nil && got["one"] == "changed-value"
+		}, 5*time.Second, 50*time.Millisecond)
+	})
+
+	t.Run("should_read_file_in_new_directory", func(t *testing.T) {
+		_, err := b.Get("new/secret")
+		assert.Error(t, err)
+		err = os.MkdirAll(filepath.Join(dir, "new"), 0700)
+		assert.NoError(t, err)
+		err = ioutil.WriteFile(filepath.Join(dir, "new", "secret.json"), []byte(`{"one": "new-value"}`), 0600)
+		assert.NoError(t, err)
+		assert.Eventually(t, func() bool {
+			got, err := b.Get("new/secret")
+			return err == nil && got["one"] == "new-value"
+		}, 5*time.Second, 50*time.Millisecond)
+	})
+}
+
+// TestDir creates a temporary directory with files and returns its path.
+func testDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "vaultsecret")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(p), 0700)
+		assert.NoError(t, err)
+		err = ioutil.WriteFile(p, []byte(content), 0600)
+		assert.NoError(t, err)
+	}
+	return dir
+}
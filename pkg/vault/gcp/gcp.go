@@ -0,0 +1,193 @@
+// Package gcp provides a backend that reads secrets from GCP Secret Manager.
+package gcp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/mmlt/vault-secret/pkg/vault"
+	"hash/crc32"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	vault.Register("gcp-secretmanager", factory)
+}
+
+// Factory creates a backend from options:
+// - endpoint is the URL of Secret Manager, defaults to https://secretmanager.googleapis.com
+// - metadata-endpoint is the URL of the metadata server that provides workload identity tokens,
+// defaults to http://$GCE_METADATA_HOST or http://metadata.google.internal
+func factory(options map[string]string) (vault.Loginer, error) {
+	endpoint := options["endpoint"]
+	if endpoint == "" {
+		endpoint = "https://secretmanager.googleapis.com"
+	}
+
+	md := options["metadata-endpoint"]
+	if md == "" {
+		md = "http://metadata.google.internal"
+		if h := os.Getenv("GCE_METADATA_HOST"); h != "" {
+			md = "http://" + h
+		}
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	return &SecretManager{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		tokens: &tokenProvider{
+			endpoint: strings.TrimSuffix(md, "/"),
+			client:   client,
+		},
+		client: client,
+	}, nil
+}
+
+// SecretManager reads secrets from GCP Secret Manager.
+//
+// The path is projects/<project>/secrets/<secret>[/versions/<version>], the version defaults to "latest".
+// A payload containing a JSON object results in a field per key, other payloads result in a "value" field.
+type SecretManager struct {
+	endpoint string
+	tokens   *tokenProvider
+	client   *http.Client
+}
+
+// Login returns the backend itself, access is controlled by the workload identity of the webhook.
+func (s *SecretManager) Login(_ vault.LoginRequest) (vault.Getter, error) {
+	return s, nil
+}
+
+// Get the secret version at path.
+func (s *SecretManager) Get(path string) (map[string]string, error) {
+	name := strings.Trim(path, "/")
+	elems := strings.Split(name, "/")
+	switch {
+	case len(elems) == 4 && elems[0] == "projects" && elems[2] == "secrets":
+		name += "/versions/latest"
+	case len(elems) == 6 && elems[0] == "projects" && elems[2] == "secrets" && elems[4] == "versions":
+	default:
+		return nil, fmt.Errorf("path %s: expected projects/<project>/secrets/<secret>[/versions/<version>]", path)
+	}
+
+	token, err := s.tokens.token()
+	if err != nil {
+		return nil, fmt.Errorf("token: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, s.endpoint+"/v1/"+name+":access", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, vault.Unavailable(err)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, vault.Unavailable(err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("path not found: %s", path)
+	}
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("path %s: %s: %s", path, resp.Status, string(b))
+		if vault.UnavailableStatus(resp.StatusCode) {
+			err = vault.Unavailable(err)
+		}
+		return nil, err
+	}
+
+	var r struct {
+		Payload struct {
+			Data       string `json:"data"`
+			DataCrc32c string `json:"dataCrc32c"`
+		} `json:"payload"`
+	}
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(r.Payload.Data)
+	if err != nil {
+		return nil, fmt.Errorf("path %s: %w", path, err)
+	}
+	if r.Payload.DataCrc32c != "" {
+		want, err := strconv.ParseUint(r.Payload.DataCrc32c, 10, 32)
+		if err != nil || crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)) != uint32(want) {
+			return nil, fmt.Errorf("path %s: payload checksum mismatch", path)
+		}
+	}
+
+	return vault.ParseJSONValue(data), nil
+}
+
+// TokenProvider gets access tokens of the workload identity from the metadata server.
+type tokenProvider struct {
+	endpoint string
+	client   *http.Client
+
+	mu      sync.Mutex
+	cached  string
+	expires time.Time
+}
+
+// Token returns a cached access token or requests a new one when it (almost) expires.
+func (p *tokenProvider) token() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cached != "" && time.Now().Add(time.Minute).Before(p.expires) {
+		return p.cached, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, p.endpoint+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", vault.Unavailable(err)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", vault.Unavailable(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("%s: %s", resp.Status, string(b))
+		if vault.UnavailableStatus(resp.StatusCode) {
+			err = vault.Unavailable(err)
+		}
+		return "", err
+	}
+
+	var r struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return "", err
+	}
+
+	p.cached = r.AccessToken
+	p.expires = time.Now().Add(time.Duration(r.ExpiresIn) * time.Second)
+
+	return p.cached, nil
+}
+
+var _ vault.Loginer = &SecretManager{}
@@ -0,0 +1,95 @@
+package gcp
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/mmlt/vault-secret/pkg/vault"
+	"github.com/stretchr/testify/assert"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSecretManager(t *testing.T) {
+	payload := func(s string, crc uint32) string {
+		return fmt.Sprintf(`{"name":"x","payload":{"data":"%s","dataCrc32c":"%d"}}`,
+			base64.StdEncoding.EncodeToString([]byte(s)), crc)
+	}
+	crc := func(s string) uint32 {
+		return crc32.Checksum([]byte(s), crc32.MakeTable(crc32.Castagnoli))
+	}
+
+	mux := http.NewServeMux()
+	// metadata server
+	mux.HandleFunc("/computeMetadata/v1/instance/service-accounts/default/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte(`{"access_token":"access-token","expires_in":3599,"token_type":"Bearer"}`))
+	})
+	// Secret Manager
+	mux.HandleFunc("/v1/projects/p/secrets/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch strings.TrimPrefix(r.URL.Path, "/v1/projects/p/secrets/") {
+		case "db/versions/latest:access":
+			s := `{"user":"superman","port":5432}`
+			_, _ = w.Write([]byte(payload(s, crc(s))))
+		case "token/versions/2:access":
+			_, _ = w.Write([]byte(payload("plain-token", crc("plain-token"))))
+		case "corrupt/versions/latest:access":
+			_, _ = w.Write([]byte(payload("plain-token", 1)))
+		case "unavailable/versions/latest:access":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	l, err := factory(map[string]string{
+		"endpoint":          srv.URL,
+		"metadata-endpoint": srv.URL,
+	})
+	assert.NoError(t, err)
+	sm := l.(*SecretManager)
+
+	t.Run("should_get_json_fields_of_latest_version", func(t *testing.T) {
+		got, err := sm.Get("projects/p/secrets/db")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"user": "superman", "port": "5432"}, got)
+	})
+
+	t.Run("should_get_plain_value_of_version", func(t *testing.T) {
+		got, err := sm.Get("projects/p/secrets/token/versions/2")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"value": "plain-token"}, got)
+	})
+
+	t.Run("should_error_on_checksum_mismatch", func(t *testing.T) {
+		_, err := sm.Get("projects/p/secrets/corrupt")
+		assert.Error(t, err)
+	})
+
+	t.Run("should_error_on_missing_secret", func(t *testing.T) {
+		_, err := sm.Get("projects/p/secrets/missing")
+		assert.EqualError(t, err, "path not found: projects/p/secrets/missing")
+	})
+
+	t.Run("should_error_on_malformed_path", func(t *testing.T) {
+		_, err := sm.Get("p/db")
+		assert.Error(t, err)
+	})
+
+	t.Run("should_wrap_unavailable_on_5xx", func(t *testing.T) {
+		_, err := sm.Get("projects/p/secrets/unavailable")
+		assert.True(t, errors.Is(err, vault.ErrUnavailable), "got %v", err)
+	})
+}
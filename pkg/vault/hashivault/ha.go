@@ -0,0 +1,238 @@
+package hashivault
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hashicorp/vault/api"
+	"github.com/mmlt/vault-secret/pkg/vault"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHealthCheckInterval is the time between health checks of the nodes of a Vault cluster.
+	defaultHealthCheckInterval = 10 * time.Second
+	// HealthCheckTimeout is the time a node has to respond to a health check.
+	healthCheckTimeout = 2 * time.Second
+)
+
+// NodeState is the state of a Vault node as reported by sys/health.
+type nodeState int
+
+const (
+	// NodeUnknown is a node that hasn't been checked yet.
+	nodeUnknown nodeState = iota
+	// NodeActive is the active node of a cluster, it serves reads and writes.
+	nodeActive
+	// NodePerfStandby is a performance standby, it serves reads and forwards writes to the active node.
+	nodePerfStandby
+	// NodeStandby is a standby, it forwards requests to the active node.
+	nodeStandby
+	// NodeDown is a node that is unreachable, sealed, not initialized or a DR secondary.
+	nodeDown
+)
+
+// Nodes tracks the health of the nodes of a Vault cluster.
+// Nodes are health checked on use when the last check is older than interval.
+type nodes struct {
+	addrs []string
+	// Interval between health checks.
+	interval time.Duration
+	// Client is used for health checks.
+	client *api.Client
+
+	mu       sync.Mutex
+	state    map[string]nodeState
+	checked  time.Time
+	checking bool
+}
+
+// NewNodes returns nodes for addrs, config is used to create health check clients.
+func newNodes(addrs []string, config *api.Config, interval time.Duration) (*nodes, error) {
+	clnt, err := api.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if interval <= 0 {
+		interval = defaultHealthCheckInterval
+	}
+	return &nodes{
+		addrs:    addrs,
+		interval: interval,
+		client:   clnt,
+		state:    make(map[string]nodeState, len(addrs)),
+	}, nil
+}
+
+// Order returns the node addresses in order of preference.
+// Reads prefer healthy nodes, writes prefer the active node.
+// Nodes that are down are returned last so they are tried when all other nodes fail.
+func (n *nodes) order(write bool) []string {
+	n.refresh()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	r := make([]string, len(n.addrs))
+	copy(r, n.addrs)
+	sort.SliceStable(r, func(i, j int) bool {
+		return rank(n.state[r[i]], write) < rank(n.state[r[j]], write)
+	})
+	return r
+}
+
+// Down marks the node at addr as down until the next health check.
+func (n *nodes) down(addr string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.state[addr] = nodeDown
+}
+
+// Do calls fn with clnt set to each node in order of preference until fn returns an error that isn't caused by an
+// unavailable node.
+// When n is nil fn is called with clnt as is.
+// Errors of unavailable nodes wrap vault.ErrUnavailable.
+func (n *nodes) do(clnt *api.Client, write bool, fn func(*api.Client) error) error {
+	if n == nil {
+		return wrapUnavailable(fn(clnt))
+	}
+
+	var err error
+	for _, addr := range n.order(write) {
+		var c *api.Client
+		c, err = clnt.Clone()
+		if err != nil {
+			return err
+		}
+		err = c.SetAddress(addr)
+		if err != nil {
+			return err
+		}
+		if t := clnt.Token(); t != "" {
+			c.SetToken(t)
+		}
+
+		err = fn(c)
+		if !unavailable(err) {
+			return err
+		}
+		n.down(addr)
+	}
+	return wrapUnavailable(err)
+}
+
+// Refresh checks the health of all nodes when the last check is older than interval.
+// Only one caller performs the check, others continue with the current state.
+func (n *nodes) refresh() {
+	n.mu.Lock()
+	if n.checking || time.Since(n.checked) < n.interval {
+		n.mu.Unlock()
+		return
+	}
+	n.checking = true
+	n.mu.Unlock()
+
+	state := make([]nodeState, len(n.addrs))
+	var wg sync.WaitGroup
+	for i, addr := range n.addrs {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			state[i] = n.check(addr)
+		}(i, addr)
+	}
+	wg.Wait()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for i, addr := range n.addrs {
+		n.state[addr] = state[i]
+	}
+	n.checked = time.Now()
+	n.checking = false
+}
+
+// Check returns the state of the node at addr.
+func (n *nodes) check(addr string) nodeState {
+	clnt, err := n.client.Clone()
+	if err != nil {
+		return nodeDown
+	}
+	clnt.SetMaxRetries(0)
+	clnt.SetClientTimeout(healthCheckTimeout)
+	err = clnt.SetAddress(addr)
+	if err != nil {
+		return nodeDown
+	}
+
+	h, err := clnt.Sys().Health()
+	switch {
+	case err != nil, !h.Initialized, h.Sealed, h.ReplicationDRMode == "secondary":
+		return nodeDown
+	case h.PerformanceStandby:
+		return nodePerfStandby
+	case h.Standby:
+		return nodeStandby
+	default:
+		return nodeActive
+	}
+}
+
+// Rank returns the preference of a node in state s, lower is better.
+func rank(s nodeState, write bool) int {
+	switch s {
+	case nodeActive:
+		return 0
+	case nodePerfStandby, nodeStandby:
+		if write {
+			return 1
+		}
+		return 0
+	case nodeUnknown:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// Unavailable returns true when err is caused by a node that can't serve requests.
+// Those requests can be retried on another node.
+func unavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		return true
+	}
+	var re *api.ResponseError
+	if errors.As(err, &re) {
+		return re.StatusCode >= http.StatusInternalServerError || re.StatusCode == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// WrapUnavailable returns err wrapping vault.ErrUnavailable when err is caused by an unavailable node.
+func wrapUnavailable(err error) error {
+	if unavailable(err) {
+		return fmt.Errorf("%w: %v", vault.ErrUnavailable, err)
+	}
+	return err
+}
+
+// SplitAddresses returns the addresses in a comma separated list.
+func splitAddresses(s string) []string {
+	var r []string
+	for _, a := range strings.Split(s, ",") {
+		a = strings.TrimSpace(a)
+		if a != "" {
+			r = append(r, a)
+		}
+	}
+	return r
+}
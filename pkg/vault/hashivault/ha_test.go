@@ -0,0 +1,177 @@
+package hashivault
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/mmlt/vault-secret/pkg/vault"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// FakeNode is a Vault node that serves sys/health, kubernetes login and reads of secret/data.
+type fakeNode struct {
+	name   string
+	health map[string]interface{}
+	// status is the status code of reads, 0 means 200.
+	status int
+
+	logins int32
+	reads  int32
+}
+
+func (n *fakeNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var resp interface{}
+	switch {
+	case r.URL.Path == "/v1/sys/health":
+		resp = n.health
+	case r.URL.Path == "/v1/auth/kubernetes/login":
+		atomic.AddInt32(&n.logins, 1)
+		resp = map[string]interface{}{"auth": map[string]interface{}{"client_token": "token"}}
+	case strings.HasPrefix(r.URL.Path, "/v1/secret/"):
+		atomic.AddInt32(&n.reads, 1)
+		if n.status != 0 {
+			w.WriteHeader(n.status)
+			resp = map[string]interface{}{"errors": []string{"unavailable"}}
+			break
+		}
+		resp = map[string]interface{}{"data": map[string]interface{}{"node": n.name}}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+var (
+	active      = map[string]interface{}{"initialized": true}
+	perfStandby = map[string]interface{}{"initialized": true, "standby": true, "performance_standby": true}
+	sealed      = map[string]interface{}{"initialized": true, "sealed": true}
+)
+
+// TestServers starts a server per node and returns the comma separated URLs and a function that stops the servers.
+func testServers(nodes ...*fakeNode) (string, func()) {
+	var urls []string
+	var srvs []*httptest.Server
+	for _, n := range nodes {
+		srv := httptest.NewServer(n)
+		srvs = append(srvs, srv)
+		urls = append(urls, srv.URL)
+	}
+	return strings.Join(urls, ","), func() {
+		for _, srv := range srvs {
+			srv.Close()
+		}
+	}
+}
+
+// TestCluster starts a server per node and returns a logged in client for the cluster and a function that stops the
+// servers.
+func testCluster(t *testing.T, nodes ...*fakeNode) (vault.Getter, func()) {
+	urls, stop := testServers(nodes...)
+
+	c, err := newConfig(urls, "", false, "")
+	if err != nil {
+		stop()
+		t.Fatal(err)
+	}
+	// fail over immediately.
+	c.config.MaxRetries = 0
+
+	g, err := c.Login(vault.LoginRequest{AuthPath: "kubernetes", Role: "test", JWT: "jwt"})
+	if err != nil {
+		stop()
+		t.Fatal(err)
+	}
+	return g, stop
+}
+
+func TestFailover(t *testing.T) {
+	t.Run("should_read_from_healthy_node", func(t *testing.T) {
+		s := &fakeNode{name: "sealed", health: sealed}
+		ps := &fakeNode{name: "standby", health: perfStandby}
+		a := &fakeNode{name: "active", health: active}
+		c, stop := testCluster(t, s, ps, a)
+		defer stop()
+
+		got, err := c.Get("secret/data/app")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"node": "standby"}, got)
+		assert.EqualValues(t, 0, s.reads)
+	})
+
+	t.Run("should_login_on_active_node", func(t *testing.T) {
+		ps := &fakeNode{name: "standby", health: perfStandby}
+		a := &fakeNode{name: "active", health: active}
+		_, stop := testCluster(t, ps, a)
+		defer stop()
+
+		assert.EqualValues(t, 0, ps.logins)
+		assert.EqualValues(t, 1, a.logins)
+	})
+
+	t.Run("should_fall_back_on_unavailable_node", func(t *testing.T) {
+		a := &fakeNode{name: "active", health: active, status: http.StatusServiceUnavailable}
+		ps := &fakeNode{name: "standby", health: perfStandby}
+		c, stop := testCluster(t, a, ps)
+		defer stop()
+
+		got, err := c.Get("secret/data/app")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"node": "standby"}, got)
+
+		// the failed node is skipped until the next health check.
+		_, err = c.Get("secret/data/app")
+		assert.NoError(t, err)
+		assert.EqualValues(t, 1, a.reads)
+		assert.EqualValues(t, 2, ps.reads)
+	})
+
+	t.Run("should_not_fall_back_on_client_errors", func(t *testing.T) {
+		ps := &fakeNode{name: "standby", health: perfStandby, status: http.StatusForbidden}
+		a := &fakeNode{name: "active", health: active}
+		c, stop := testCluster(t, ps, a)
+		defer stop()
+
+		_, err := c.Get("secret/data/app")
+		assert.Error(t, err)
+		assert.EqualValues(t, 0, a.reads)
+	})
+}
+
+func TestHealth(t *testing.T) {
+	tests := []struct {
+		it      string
+		health  []map[string]interface{}
+		wantErr bool
+	}{
+		{it: "should_be_healthy_when_single_node_is_active", health: []map[string]interface{}{active}},
+		{it: "should_be_unhealthy_when_single_node_is_sealed", health: []map[string]interface{}{sealed}, wantErr: true},
+		{it: "should_be_healthy_when_a_node_is_unsealed", health: []map[string]interface{}{sealed, perfStandby}},
+		{it: "should_be_unhealthy_when_all_nodes_are_sealed", health: []map[string]interface{}{sealed, sealed}, wantErr: true},
+	}
+	for _, tst := range tests {
+		t.Run(tst.it, func(t *testing.T) {
+			var nodes []*fakeNode
+			for _, h := range tst.health {
+				nodes = append(nodes, &fakeNode{health: h})
+			}
+			urls, stop := testServers(nodes...)
+			defer stop()
+			c, err := newConfig(urls, "", false, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = c.Health()
+			if tst.wantErr {
+				assert.True(t, errors.Is(err, vault.ErrUnavailable), "got %v", err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
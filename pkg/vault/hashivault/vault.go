@@ -1,17 +1,90 @@
 package hashivault
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"github.com/hashicorp/vault/api"
 	"github.com/mmlt/vault-secret/pkg/vault"
 	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
+func init() {
+	vault.Register("hashivault", factory)
+}
+
+// Factory creates a client from options:
+// - url is the URL of the Vault server or a comma separated list of URLs of the nodes of a Vault cluster.
+// - health-check-interval is the time between health checks of the nodes, defaults to 10s.
+// - ca-file is the path of the Vault server CA.
+// - tls-insecure "true" disables TLS checks.
+// - auth-method is "kubernetes" (default) or "jwt", both login with a ServiceAccount token.
+// - auth-path is the mount path of the auth method, defaults to vault-auth-path for kubernetes and "jwt" for jwt.
+func factory(options map[string]string) (vault.Loginer, error) {
+	var ca []byte
+	if f := options["ca-file"]; f != "" {
+		var err error
+		ca, err = ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var insecure bool
+	if s := options["tls-insecure"]; s != "" {
+		var err error
+		insecure, err = strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("tls-insecure: %w", err)
+		}
+	}
+
+	if options["url"] == "" {
+		return nil, fmt.Errorf("url is required")
+	}
+
+	authPath := options["auth-path"]
+	switch options["auth-method"] {
+	case "", "kubernetes":
+	case "jwt":
+		if authPath == "" {
+			authPath = "jwt"
+		}
+	default:
+		return nil, fmt.Errorf("auth-method: expected kubernetes or jwt, got %q", options["auth-method"])
+	}
+
+	var interval time.Duration
+	if s := options["health-check-interval"]; s != "" {
+		var err error
+		interval, err = time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("health-check-interval: %w", err)
+		}
+	}
+
+	c, err := newConfig(options["url"], string(ca), insecure, "")
+	if err != nil {
+		return nil, err
+	}
+	c.authPath = authPath
+	if c.nodes != nil && interval > 0 {
+		c.nodes.interval = interval
+	}
+	return c, nil
+}
+
 // New returns a config to access Vault with kubernetes authentication.
 // Expect to be running in-cluster.
-// - url is the URL of the Vault server.
+// - url is the URL of the Vault server or a comma separated list of URLs of the nodes of a Vault cluster.
 // - ca is the CA of the Vault server.
 // - insecure true disables TLS checks.
+// With multiple URLs reads go to healthy nodes, writes prefer the active node and requests fail over to the next
+// node when a node is unavailable.
 func New(url, ca string, insecure bool) (vault.Loginer, error) {
 	return NewOutsideCluster(url, ca, insecure, "")
 }
@@ -19,16 +92,30 @@ func New(url, ca string, insecure bool) (vault.Loginer, error) {
 // New returns a config to access Vault with kubernetes authentication.
 // Expect to be running out-of-cluster.
 func NewOutsideCluster(url, ca string, insecure bool, jwt string) (vault.Loginer, error) {
+	return newConfig(url, ca, insecure, jwt)
+}
+
+// NewConfig returns a config, jwt is only set when running out-of-cluster.
+func newConfig(url, ca string, insecure bool, jwt string) (*config, error) {
 	c := &config{
 		config: api.DefaultConfig(),
 		jwt:    jwt,
 	}
-	c.config.Address = url
+	addrs := splitAddresses(url)
+	if len(addrs) > 0 {
+		c.config.Address = addrs[0]
+	}
 	err := c.config.ConfigureTLS(&api.TLSConfig{
 		CACert:   ca,
 		Insecure: insecure,
 	})
+	if err != nil {
+		return nil, err
+	}
 
+	if len(addrs) > 1 {
+		c.nodes, err = newNodes(addrs, c.config, defaultHealthCheckInterval)
+	}
 	return c, err
 }
 
@@ -39,12 +126,35 @@ type config struct {
 	// JWT is the token to authenticate with k8s API server.
 	// Only set when testing
 	jwt string
+	// AuthPath overrides the AuthPath of login requests when set.
+	authPath string
+	// Nodes of a Vault cluster, nil when there is a single Vault address.
+	nodes *nodes
+}
+
+// Health returns an error when none of the Vault nodes is able to serve requests.
+func (c *config) Health() error {
+	n := c.nodes
+	if n == nil {
+		var err error
+		n, err = newNodes([]string{c.config.Address}, c.config, 0)
+		if err != nil {
+			return err
+		}
+	}
+	for _, addr := range n.addrs {
+		if n.check(addr) != nodeDown {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: no Vault node is unsealed and reachable at %s", vault.ErrUnavailable, strings.Join(n.addrs, ","))
 }
 
 // Login and on success set vault token in the receiver.
 // AuthPath is the path of the Vault credential backend mount, typically "kubernetes"
 // Role is a Vault role.
-func (c *config) Login(authPath, role string) (vault.Getter, error) {
+// JWT (when set) is used instead of the ServiceAccount token of the pod.
+func (c *config) Login(req vault.LoginRequest) (vault.Getter, error) {
 	const tokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
 
 	clnt, err := api.NewClient(c.config)
@@ -53,7 +163,10 @@ func (c *config) Login(authPath, role string) (vault.Getter, error) {
 	}
 
 	var jwt string
-	if c.jwt == "" {
+	if req.JWT != "" {
+		// impersonating the requester
+		jwt = req.JWT
+	} else if c.jwt == "" {
 		// running as pod in cluster
 		b, err := ioutil.ReadFile(tokenPath)
 		if err != nil {
@@ -65,9 +178,18 @@ func (c *config) Login(authPath, role string) (vault.Getter, error) {
 		jwt = c.jwt
 	}
 
+	authPath := req.AuthPath
+	if c.authPath != "" {
+		authPath = c.authPath
+	}
 	p := fmt.Sprintf("auth/%s/login", authPath)
-	d := map[string]interface{}{"jwt": jwt, "role": role}
-	secret, err := clnt.Logical().Write(p, d) //TODO retry or let caller retry?
+	d := map[string]interface{}{"jwt": jwt, "role": req.Role}
+	var secret *api.Secret
+	err = c.nodes.do(clnt, true, func(clnt *api.Client) error {
+		var err error
+		secret, err = clnt.Logical().Write(p, d)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -76,16 +198,24 @@ func (c *config) Login(authPath, role string) (vault.Getter, error) {
 
 	return &client{
 		client: clnt,
+		nodes:  c.nodes,
 	}, nil
 }
 
 // Client to access Vault.
 type client struct {
 	client *api.Client
+	// Nodes of a Vault cluster, nil when there is a single Vault address.
+	nodes *nodes
 }
 
 func (c *client) Get(path string) (map[string]string, error) {
-	secret, err := c.client.Logical().Read(path)
+	var secret *api.Secret
+	err := c.nodes.do(c.client, false, func(clnt *api.Client) error {
+		var err error
+		secret, err = clnt.Logical().Read(path)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -114,6 +244,181 @@ func (c *client) Get(path string) (map[string]string, error) {
 	return r, nil
 }
 
+// Wrap reads the secret at path as a response-wrapped secret and returns the wrapping token.
+// The token can be unwrapped once (vault unwrap <token>) before ttl expires.
+func (c *client) Wrap(path string, ttl time.Duration) (string, error) {
+	var secret *api.Secret
+	var notFound bool
+	err := c.nodes.do(c.client, false, func(clnt *api.Client) error {
+		r := clnt.NewRequest(http.MethodGet, "/v1/"+path)
+		r.WrapTTL = fmt.Sprintf("%ds", int64(ttl.Seconds()))
+
+		resp, err := clnt.RawRequest(r)
+		if resp != nil {
+			defer resp.Body.Close()
+			if resp.StatusCode == http.StatusNotFound {
+				notFound = true
+				return nil
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		secret, err = api.ParseSecret(resp.Body)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if notFound {
+		return "", fmt.Errorf("path not found: %s", path)
+	}
+	if secret == nil || secret.WrapInfo == nil {
+		return "", fmt.Errorf("path %s: response is not wrapped", path)
+	}
+	return secret.WrapInfo.Token, nil
+}
+
+// Put merges values into the KV v1 or v2 secret at path.
+// KV v2 writes use check-and-set so updates made by others between read and write are not lost.
+func (c *client) Put(path string, values map[string]string) error {
+	return c.nodes.do(c.client, true, func(clnt *api.Client) error {
+		return put(clnt, path, values)
+	})
+}
+
+// Put merges values into the KV v1 or v2 secret at path using clnt.
+func put(clnt *api.Client, path string, values map[string]string) error {
+	mount, v2, err := kvMount(clnt, path)
+	if err != nil {
+		return err
+	}
+
+	if v2 && !strings.HasPrefix(path, mount+"data/") {
+		path = mount + "data/" + strings.TrimPrefix(path, mount)
+	}
+
+	secret, err := clnt.Logical().Read(path)
+	if err != nil {
+		return err
+	}
+
+	current := map[string]interface{}{}
+	cas := int64(0)
+	if secret != nil && secret.Data != nil {
+		current = secret.Data
+		if v2 {
+			if d, ok := secret.Data["data"].(map[string]interface{}); ok {
+				current = d
+			} else {
+				// deleted version
+				current = map[string]interface{}{}
+			}
+			if md, ok := secret.Data["metadata"].(map[string]interface{}); ok {
+				if n, ok := md["version"].(json.Number); ok {
+					cas, _ = n.Int64()
+				}
+			}
+		}
+	}
+
+	changed := false
+	merged := make(map[string]interface{}, len(current)+len(values))
+	for k, v := range current {
+		merged[k] = v
+	}
+	for k, v := range values {
+		if cv, ok := current[k]; !ok || fmt.Sprint(cv) != v {
+			changed = true
+		}
+		merged[k] = v
+	}
+	if !changed {
+		return nil
+	}
+
+	var d map[string]interface{}
+	if v2 {
+		d = map[string]interface{}{
+			"data":    merged,
+			"options": map[string]interface{}{"cas": cas},
+		}
+	} else {
+		d = merged
+	}
+	_, err = clnt.Logical().Write(path, d)
+	return err
+}
+
+// Decrypt returns the plaintext of Vault transit ciphertexts (vault:v1:...) by field name.
+// Key is the name of the transit key, optionally prefixed by the mount path when not mounted at "transit",
+// for example "transit-eu/app".
+func (c *client) Decrypt(key string, ciphertexts map[string]string) (map[string]string, error) {
+	names := make([]string, 0, len(ciphertexts))
+	batch := make([]interface{}, 0, len(ciphertexts))
+	for n, ct := range ciphertexts {
+		names = append(names, n)
+		batch = append(batch, map[string]interface{}{"ciphertext": ct})
+	}
+
+	p := vault.DecryptPath(key)
+	var secret *api.Secret
+	err := c.nodes.do(c.client, false, func(clnt *api.Client) error {
+		var err error
+		secret, err = clnt.Logical().Write(p, map[string]interface{}{"batch_input": batch})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, fmt.Errorf("%s: no response", p)
+	}
+
+	results, _ := secret.Data["batch_results"].([]interface{})
+	if len(results) != len(names) {
+		return nil, fmt.Errorf("%s: expected %d results, got %d", p, len(names), len(results))
+	}
+	r := make(map[string]string, len(names))
+	for i, res := range results {
+		m, _ := res.(map[string]interface{})
+		if e, _ := m["error"].(string); e != "" {
+			return nil, fmt.Errorf("%s: field %s: %s", p, names[i], e)
+		}
+		pt, _ := m["plaintext"].(string)
+		b, err := base64.StdEncoding.DecodeString(pt)
+		if err != nil {
+			return nil, fmt.Errorf("%s: field %s: %w", p, names[i], err)
+		}
+		r[names[i]] = string(b)
+	}
+	return r, nil
+}
+
+// KvMount returns the mount path (with trailing slash) of the KV secret engine that serves path and true when it's
+// a KV version 2 engine.
+func kvMount(clnt *api.Client, path string) (string, bool, error) {
+	secret, err := clnt.Logical().Read("sys/internal/ui/mounts/" + path)
+	if err != nil {
+		return "", false, err
+	}
+	if secret == nil || secret.Data == nil {
+		return "", false, fmt.Errorf("no mount found for path: %s", path)
+	}
+
+	mount, _ := secret.Data["path"].(string)
+	if t, _ := secret.Data["type"].(string); t != "kv" {
+		return "", false, fmt.Errorf("path %s is not a kv mount", path)
+	}
+	v2 := false
+	if opts, ok := secret.Data["options"].(map[string]interface{}); ok {
+		v2 = opts["version"] == "2"
+	}
+
+	return mount, v2, nil
+}
+
 // NewAlreadyLoggedIn returns a config to access Vault with an already authenticated client.
 // Mainly for testing.
 func NewAlreadyLoggedIn(client *api.Client) *loggedinClient {
@@ -125,8 +430,12 @@ type loggedinClient struct {
 	client *api.Client
 }
 
-func (c *loggedinClient) Login(_, _ string) (vault.Getter, error) {
+func (c *loggedinClient) Login(_ vault.LoginRequest) (vault.Getter, error) {
 	return &client{client: c.client}, nil
 }
 
 var _ vault.Loginer = &loggedinClient{}
+var _ vault.Putter = &client{}
+var _ vault.Decrypter = &client{}
+var _ vault.Wrapper = &client{}
+var _ vault.HealthChecker = &config{}
@@ -0,0 +1,148 @@
+package hashivault
+
+import (
+	"encoding/json"
+	"github.com/hashicorp/vault/api"
+	"github.com/mmlt/vault-secret/pkg/vault"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// FakeKV is a Vault that serves a single KV mount.
+type fakeKV struct {
+	// mount is the mount path with trailing slash.
+	mount string
+	// version is the KV engine version "1" or "2".
+	version string
+	// secrets are the responses of reads by path (without /v1/ prefix).
+	secrets map[string]interface{}
+
+	mu sync.Mutex
+	// writes are the request bodies of writes by path.
+	writes map[string]map[string]interface{}
+}
+
+func (kv *fakeKV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := strings.TrimPrefix(r.URL.Path, "/v1/")
+	switch {
+	case strings.HasPrefix(p, "sys/internal/ui/mounts/"):
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+			"path":    kv.mount,
+			"type":    "kv",
+			"options": map[string]interface{}{"version": kv.version},
+		}})
+	case r.Method == http.MethodGet:
+		s, ok := kv.secrets[p]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{}})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": s})
+	case r.Method == http.MethodPut || r.Method == http.MethodPost:
+		body := map[string]interface{}{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		kv.mu.Lock()
+		kv.writes[p] = body
+		kv.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// TestPutter starts kv and returns a Putter that writes to it.
+func testPutter(t *testing.T, kv *fakeKV) (vault.Putter, func()) {
+	kv.writes = map[string]map[string]interface{}{}
+	srv := httptest.NewServer(kv)
+
+	cfg := api.DefaultConfig()
+	cfg.Address = srv.URL
+	cfg.MaxRetries = 0
+	clnt, err := api.NewClient(cfg)
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	clnt.SetToken("token")
+
+	g, err := NewAlreadyLoggedIn(clnt).Login(vault.LoginRequest{})
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	return g.(vault.Putter), srv.Close
+}
+
+func TestVault(t *testing.T) {
+	t.Run("should_put_to_kv_v1_mount", func(t *testing.T) {
+		kv := &fakeKV{
+			mount:   "secret/",
+			version: "1",
+			secrets: map[string]interface{}{"secret/app": map[string]interface{}{"keep": "x", "user": "old"}},
+		}
+		p, stop := testPutter(t, kv)
+		defer stop()
+
+		err := p.Put("secret/app", map[string]string{"user": "new"})
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]map[string]interface{}{
+			"secret/app": {"keep": "x", "user": "new"},
+		}, kv.writes)
+	})
+
+	t.Run("should_insert_data_in_kv_v2_path_and_use_cas", func(t *testing.T) {
+		kv := &fakeKV{
+			mount:   "kv/",
+			version: "2",
+			secrets: map[string]interface{}{"kv/data/app": map[string]interface{}{
+				"data":     map[string]interface{}{"keep": "x"},
+				"metadata": map[string]interface{}{"version": 3},
+			}},
+		}
+		p, stop := testPutter(t, kv)
+		defer stop()
+
+		err := p.Put("kv/app", map[string]string{"user": "new"})
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]map[string]interface{}{
+			"kv/data/app": {
+				"data":    map[string]interface{}{"keep": "x", "user": "new"},
+				"options": map[string]interface{}{"cas": float64(3)},
+			},
+		}, kv.writes)
+	})
+
+	t.Run("should_create_kv_v2_secret_with_cas_0", func(t *testing.T) {
+		kv := &fakeKV{mount: "kv/", version: "2"}
+		p, stop := testPutter(t, kv)
+		defer stop()
+
+		err := p.Put("kv/data/new", map[string]string{"user": "new"})
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]map[string]interface{}{
+			"kv/data/new": {
+				"data":    map[string]interface{}{"user": "new"},
+				"options": map[string]interface{}{"cas": float64(0)},
+			},
+		}, kv.writes)
+	})
+
+	t.Run("should_not_write_unchanged_values", func(t *testing.T) {
+		kv := &fakeKV{
+			mount:   "secret/",
+			version: "1",
+			secrets: map[string]interface{}{"secret/app": map[string]interface{}{"user": "same"}},
+		}
+		p, stop := testPutter(t, kv)
+		defer stop()
+
+		err := p.Put("secret/app", map[string]string{"user": "same"})
+		assert.NoError(t, err)
+		assert.Empty(t, kv.writes)
+	})
+}
@@ -0,0 +1,118 @@
+// Package kubernetes provides a backend that reads secrets from Kubernetes Secrets in other namespaces.
+// It's intended to replicate Secrets like wildcard TLS certificates or registry credentials from a central namespace.
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"github.com/mmlt/vault-secret/pkg/vault"
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+
+// AllowedNamespacesAnnotation is set on the Namespace of source Secrets to permit copying them.
+// The value is a comma separated list of target namespaces, shell patterns like "team-*" are allowed.
+// The annotation is read from the Namespace so a Secret can't widen its own allow-list.
+const AllowedNamespacesAnnotation = "vault.mmlt.nl/allowed-namespaces"
+
+// Register makes the "kubernetes" backend type available.
+// The backend reads Secrets with reader, typically the API reader of the manager.
+func Register(reader client.Reader) {
+	vault.Register("kubernetes", func(_ map[string]string) (vault.Loginer, error) {
+		return New(reader), nil
+	})
+}
+
+// New returns a backend that reads Secrets with reader.
+// The path is "<namespace>/<name>" of the source Secret, its data keys become fields.
+// A Secret can only be read when the AllowedNamespacesAnnotation of its Namespace permits the namespace of the requester.
+func New(reader client.Reader) *Backend {
+	return &Backend{reader: reader}
+}
+
+// Backend reads Kubernetes Secrets.
+type Backend struct {
+	reader client.Reader
+}
+
+// Login returns a Getter that reads Secrets on behalf of req.Namespace.
+func (b *Backend) Login(req vault.LoginRequest) (vault.Getter, error) {
+	if req.Namespace == "" {
+		return nil, fmt.Errorf("namespace of requester is required")
+	}
+	return &getter{reader: b.reader, namespace: req.Namespace}, nil
+}
+
+// Getter reads Secrets from Namespaces that allow them to be copied to namespace.
+type getter struct {
+	reader    client.Reader
+	namespace string
+}
+
+// Get the data of the Secret at path.
+func (g *getter) Get(p string) (map[string]string, error) {
+	nsn, err := parsePath(p)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := &corev1.Secret{}
+	err = g.reader.Get(context.Background(), nsn, secret)
+	if apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("path not found: %s", p)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ns := &corev1.Namespace{}
+	err = g.reader.Get(context.Background(), types.NamespacedName{Name: nsn.Namespace}, ns)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	if !allowed(ns.Annotations[AllowedNamespacesAnnotation], g.namespace) {
+		// same message as not found to avoid disclosing the existence of Secrets.
+		return nil, fmt.Errorf("path not found: %s", p)
+	}
+
+	r := make(map[string]string, len(secret.Data))
+	for k, v := range secret.Data {
+		r[k] = string(v)
+	}
+	return r, nil
+}
+
+// ParsePath returns the namespace and name of the Secret at path "namespace/name".
+func parsePath(p string) (types.NamespacedName, error) {
+	elems := strings.Split(strings.Trim(p, "/"), "/")
+	if len(elems) != 2 ||
+		len(validation.IsDNS1123Label(elems[0])) > 0 ||
+		len(validation.IsDNS1123Subdomain(elems[1])) > 0 {
+		return types.NamespacedName{}, fmt.Errorf("path %s: expected <namespace>/<name>", p)
+	}
+	return types.NamespacedName{Namespace: elems[0], Name: elems[1]}, nil
+}
+
+// Allowed returns true when namespace matches one of the comma separated patterns in list.
+func allowed(list, namespace string) bool {
+	for _, pat := range strings.Split(list, ",") {
+		pat = strings.TrimSpace(pat)
+		if pat == "" {
+			continue
+		}
+		if ok, _ := path.Match(pat, namespace); ok {
+			return true
+		}
+	}
+	return false
+}
+
+var _ vault.Loginer = &Backend{}
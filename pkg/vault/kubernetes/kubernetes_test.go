@@ -0,0 +1,116 @@
+package kubernetes
+
+import (
+	"github.com/mmlt/vault-secret/pkg/vault"
+	"github.com/stretchr/testify/assert"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestBackend(t *testing.T) {
+	namespace := func(name, allowed string) *corev1.Namespace {
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		if allowed != "" {
+			ns.Annotations = map[string]string{AllowedNamespacesAnnotation: allowed}
+		}
+		return ns
+	}
+	secret := func(namespace, name, allowed string) *corev1.Secret {
+		s := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Data: map[string][]byte{
+				"tls.crt": []byte("cert"),
+				"tls.key": []byte("key"),
+			},
+		}
+		if allowed != "" {
+			s.Annotations = map[string]string{AllowedNamespacesAnnotation: allowed}
+		}
+		return s
+	}
+	b := New(fake.NewFakeClient(
+		namespace("central", "team-*, default"),
+		namespace("private", ""),
+		secret("central", "wildcard-tls", ""),
+		secret("central", "widened", "*"),
+		secret("private", "tls", "*"),
+	))
+
+	tests := []struct {
+		it        string
+		namespace string
+		path      string
+		want      map[string]string
+		wantErr   string
+	}{
+		{
+			it:        "should_copy_to_namespace_matching_pattern",
+			namespace: "team-a",
+			path:      "central/wildcard-tls",
+			want:      map[string]string{"tls.crt": "cert", "tls.key": "key"},
+		},
+		{
+			it:        "should_copy_to_listed_namespace",
+			namespace: "default",
+			path:      "central/wildcard-tls",
+			want:      map[string]string{"tls.crt": "cert", "tls.key": "key"},
+		},
+		{
+			it:        "should_not_copy_to_unlisted_namespace",
+			namespace: "other",
+			path:      "central/wildcard-tls",
+			wantErr:   "path not found: central/wildcard-tls",
+		},
+		{
+			it:        "should_not_copy_from_Namespace_without_annotation",
+			namespace: "team-a",
+			path:      "private/tls",
+			wantErr:   "path not found: private/tls",
+		},
+		{
+			it:        "should_not_let_Secret_widen_allow_list",
+			namespace: "other",
+			path:      "central/widened",
+			wantErr:   "path not found: central/widened",
+		},
+		{
+			it:        "should_not_copy_from_missing_Namespace",
+			namespace: "team-a",
+			path:      "missing/tls",
+			wantErr:   "path not found: missing/tls",
+		},
+		{
+			it:        "should_error_on_missing_Secret",
+			namespace: "team-a",
+			path:      "central/missing",
+			wantErr:   "path not found: central/missing",
+		},
+		{
+			it:        "should_error_on_malformed_path",
+			namespace: "team-a",
+			path:      "central/../x/y",
+			wantErr:   "path central/../x/y: expected <namespace>/<name>",
+		},
+	}
+	for _, tst := range tests {
+		t.Run(tst.it, func(t *testing.T) {
+			g, err := b.Login(vault.LoginRequest{Namespace: tst.namespace})
+			assert.NoError(t, err)
+			got, err := g.Get(tst.path)
+			if tst.wantErr != "" {
+				assert.EqualError(t, err, tst.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tst.want, got)
+		})
+	}
+
+	t.Run("should_require_namespace_of_requester", func(t *testing.T) {
+		_, err := b.Login(vault.LoginRequest{})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,262 @@
+package vault
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Factory creates a backend from backend type specific options.
+type Factory func(options map[string]string) (Loginer, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[string]Factory{}
+)
+
+// Register makes a backend type available by name.
+// Typically called from the init() function of a backend package.
+// Register panics when called twice with the same type or when factory is nil.
+func Register(typ string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if factory == nil {
+		panic("vault: Register factory is nil")
+	}
+	if _, dup := factories[typ]; dup {
+		panic("vault: Register called twice for backend type " + typ)
+	}
+	factories[typ] = factory
+}
+
+// Types returns a sorted list of registered backend types.
+func Types() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	r := make([]string, 0, len(factories))
+	for t := range factories {
+		r = append(r, t)
+	}
+	sort.Strings(r)
+	return r
+}
+
+// New returns a backend of a registered type.
+func New(typ string, options map[string]string) (Loginer, error) {
+	factoriesMu.RLock()
+	f, ok := factories[typ]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown backend type %q (forgotten import?)", typ)
+	}
+	return f(options)
+}
+
+// BackendConfig is the configuration of a named backend.
+type BackendConfig struct {
+	// Name of the backend as used in annotations.
+	Name string `json:"name"`
+	// Type of the backend, see Types().
+	Type string `json:"type"`
+	// Options are backend type specific.
+	Options map[string]string `json:"options,omitempty"`
+	// SecretPath is the template that results in the path of a secret in this backend.
+	// Defaults to the --vault-secret-path template for hashivault backends and to "{p}" for other types.
+	SecretPath string `json:"secretPath,omitempty"`
+}
+
+// Backends is a set of named backends.
+// The default backend has an empty name.
+type Backends struct {
+	mu sync.RWMutex
+	m  map[string]Loginer
+	// raw are the backends of m before decoration.
+	raw map[string]Loginer
+	// decorate is applied to the backends set by Replace.
+	decorate Decorator
+	// paths are the path templates of the backends set by Replace, see SecretPath.
+	paths map[string]string
+}
+
+// NewBackends returns a set of backends with def as the default backend.
+func NewBackends(def Loginer) *Backends {
+	return &Backends{
+		m: map[string]Loginer{"": def},
+	}
+}
+
+// Get returns the backend with name, an empty name returns the default backend.
+func (b *Backends) Get(name string) (Loginer, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	l, ok := b.m[name]
+	if !ok || l == nil {
+		if name == "" {
+			return nil, fmt.Errorf("no default backend")
+		}
+		return nil, fmt.Errorf("unknown backend %q", name)
+	}
+	return l, nil
+}
+
+// SecretPath returns the path template of the backend with name.
+// Without a configured template the default backend and hashivault backends use def (the --vault-secret-path template),
+// other backends use the path as is.
+func (b *Backends) SecretPath(name, def string) string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if p, ok := b.paths[name]; ok {
+		return p
+	}
+	return def
+}
+
+// Health returns the result of the health check of the backend with name, an empty name checks the default backend.
+// The backend is checked before decoration so the result isn't affected by caching or circuit breaking.
+// Backends that don't implement HealthChecker are considered healthy.
+func (b *Backends) Health(name string) error {
+	b.mu.RLock()
+	l, ok := b.raw[name]
+	if !ok {
+		l, ok = b.m[name]
+	}
+	b.mu.RUnlock()
+	if !ok || l == nil {
+		if name == "" {
+			return fmt.Errorf("no default backend")
+		}
+		return fmt.Errorf("unknown backend %q", name)
+	}
+
+	hc, ok := l.(HealthChecker)
+	if !ok {
+		return nil
+	}
+	return hc.Health()
+}
+
+// SetDecorator sets a function that wraps each backend set by Replace, for example to add caching.
+// Nil disables decoration.
+func (b *Backends) SetDecorator(decorate Decorator) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.decorate = decorate
+}
+
+// Replace replaces all backends by def and the backends in configs.
+// When a backend can't be created an error is returned and the set is left unchanged.
+// Replaced backends and decorators that implement io.Closer are closed.
+func (b *Backends) Replace(def Loginer, configs []BackendConfig) error {
+	b.mu.RLock()
+	decorate := b.decorate
+	b.mu.RUnlock()
+
+	s, err := b.Stage(def, configs, decorate)
+	if err != nil {
+		return err
+	}
+	s.Commit()
+	return nil
+}
+
+// Staged are backends created by Stage that aren't in use yet.
+type Staged struct {
+	b *Backends
+	// m are the decorated backends of raw.
+	m   map[string]Loginer
+	raw map[string]Loginer
+	// paths are the path templates, see Backends.SecretPath.
+	paths    map[string]string
+	decorate Decorator
+}
+
+// Stage creates the backends in configs and decorates them and def with decorate (nil disables decoration).
+// The set is left unchanged until Commit is called, call Discard to close the staged backends instead.
+// When a backend can't be created an error is returned.
+func (b *Backends) Stage(def Loginer, configs []BackendConfig, decorate Decorator) (*Staged, error) {
+	raw := make(map[string]Loginer, len(configs)+1)
+	paths := make(map[string]string, len(configs))
+	for _, c := range configs {
+		if c.Name == "" {
+			closeAll(raw)
+			return nil, fmt.Errorf("backend of type %q: name is required", c.Type)
+		}
+		l, err := New(c.Type, c.Options)
+		if err != nil {
+			closeAll(raw)
+			return nil, fmt.Errorf("backend %s: %w", c.Name, err)
+		}
+		raw[c.Name] = l
+		switch {
+		case c.SecretPath != "":
+			paths[c.Name] = c.SecretPath
+		case c.Type != "hashivault":
+			paths[c.Name] = "{p}"
+		}
+	}
+	raw[""] = def
+
+	m := raw
+	if decorate != nil {
+		m = make(map[string]Loginer, len(raw))
+		for n, l := range raw {
+			m[n] = decorate(n, l)
+		}
+	}
+
+	return &Staged{b: b, m: m, raw: raw, paths: paths, decorate: decorate}, nil
+}
+
+// Commit replaces the backends of the set by the staged backends.
+// Replaced backends and decorators that implement io.Closer are closed.
+func (s *Staged) Commit() {
+	b := s.b
+	b.mu.Lock()
+	old, oldRaw := b.m, b.raw
+	if oldRaw == nil {
+		oldRaw = old
+	}
+	m, raw := s.m, s.raw
+	b.m, b.raw, b.paths, b.decorate = m, raw, s.paths, s.decorate
+	b.mu.Unlock()
+
+	for n, l := range old {
+		if m[n] == l {
+			continue
+		}
+		if oldRaw[n] != l {
+			// close the decorator.
+			if c, ok := l.(io.Closer); ok {
+				_ = c.Close()
+			}
+		}
+		if raw[n] == oldRaw[n] {
+			continue
+		}
+		if c, ok := oldRaw[n].(io.Closer); ok {
+			_ = c.Close()
+		}
+	}
+}
+
+// Discard closes the staged backends and decorators that implement io.Closer.
+func (s *Staged) Discard() {
+	for n, l := range s.m {
+		if s.raw[n] != l {
+			if c, ok := l.(io.Closer); ok {
+				_ = c.Close()
+			}
+		}
+	}
+	closeAll(s.raw)
+}
+
+// CloseAll closes the backends in m that implement io.Closer.
+func closeAll(m map[string]Loginer) {
+	for _, l := range m {
+		if c, ok := l.(io.Closer); ok {
+			_ = c.Close()
+		}
+	}
+}
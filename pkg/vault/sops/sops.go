@@ -0,0 +1,483 @@
+// Package sops provides a backend that reads secrets from SOPS encrypted YAML or JSON files.
+// Files must be encrypted with age keys (https://github.com/mozilla/sops#encrypting-using-age).
+package sops
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/mmlt/vault-secret/pkg/vault"
+	"hash"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"gopkg.in/yaml.v2"
+)
+
+func init() {
+	vault.Register("sops", factory)
+}
+
+// Factory creates a backend from options:
+// - dir is the directory containing the encrypted files.
+// - age-key-file is the path of a file with age identities (AGE-SECRET-KEY-...), defaults to $SOPS_AGE_KEY_FILE.
+func factory(options map[string]string) (vault.Loginer, error) {
+	if options["dir"] == "" {
+		return nil, fmt.Errorf("dir is required")
+	}
+
+	kf := options["age-key-file"]
+	if kf == "" {
+		kf = os.Getenv("SOPS_AGE_KEY_FILE")
+	}
+	if kf == "" {
+		return nil, fmt.Errorf("age-key-file is required")
+	}
+	f, err := os.Open(kf)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ids, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, fmt.Errorf("age-key-file: %w", err)
+	}
+
+	return New(options["dir"], ids)
+}
+
+// New returns a backend that reads SOPS files from dir and decrypts them with identities.
+//
+// A path consists of a file name (without .yaml, .yml or .json extension) optionally followed by keys of nested
+// mappings in that file. For example path "team/app/db" reads file "dir/team/app.yaml" and returns the values of
+// mapping "db" (or file "dir/team/app/db.yaml" when it exists).
+// Scalar values are returned as is, nested mappings and lists are returned as JSON.
+//
+// Decrypted files are cached by file hash.
+// The SOPS MAC is verified so values can't be changed, added or removed without the data key.
+// Unencrypted values are only accepted for keys that the unencrypted_suffix, encrypted_suffix, unencrypted_regex or
+// encrypted_regex rules in the SOPS metadata leave unencrypted.
+func New(dir string, identities []age.Identity) (*Backend, error) {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("not a directory: %s", dir)
+	}
+	if len(identities) == 0 {
+		return nil, fmt.Errorf("no age identities")
+	}
+	return &Backend{
+		dir:        dir,
+		identities: identities,
+		cache:      map[string]*entry{},
+	}, nil
+}
+
+// Backend reads secrets from SOPS files.
+type Backend struct {
+	dir        string
+	identities []age.Identity
+
+	mu sync.Mutex
+	// cache of decrypted files by file name.
+	cache map[string]*entry
+}
+
+// Entry is a decrypted file.
+type entry struct {
+	hash [sha256.Size]byte
+	tree map[string]interface{}
+}
+
+// Login returns the backend itself, access is controlled by the age keys.
+func (b *Backend) Login(_ vault.LoginRequest) (vault.Getter, error) {
+	return b, nil
+}
+
+// Get values from the file and keys in path.
+func (b *Backend) Get(path string) (map[string]string, error) {
+	f, keys, err := b.find(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := b.decrypted(f)
+	if err != nil {
+		return nil, fmt.Errorf("path %s: %w", path, err)
+	}
+
+	var node interface{} = tree
+	for _, k := range keys {
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("path not found: %s", path)
+		}
+		node, ok = m[k]
+		if !ok {
+			return nil, fmt.Errorf("path not found: %s", path)
+		}
+	}
+	m, ok := node.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("path %s: not a mapping", path)
+	}
+
+	r := make(map[string]string, len(m))
+	for k, v := range m {
+		switch v.(type) {
+		case map[string]interface{}, []interface{}:
+			b, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			r[k] = string(b)
+		default:
+			r[k] = fmt.Sprint(v)
+		}
+	}
+	return r, nil
+}
+
+// Find returns the file for path and the remaining path elements (the keys within the file).
+// The longest path prefix that matches a file wins.
+func (b *Backend) find(path string) (string, []string, error) {
+	elems := strings.Split(strings.Trim(path, "/"), "/")
+	for i := len(elems); i > 0; i-- {
+		f := filepath.Join(b.dir, filepath.FromSlash(strings.Join(elems[:i], "/")))
+		if !strings.HasPrefix(f, filepath.Clean(b.dir)+string(filepath.Separator)) {
+			return "", nil, fmt.Errorf("path outside of backend: %s", path)
+		}
+		for _, ext := range []string{".yaml", ".yml", ".json"} {
+			if fi, err := os.Stat(f + ext); err == nil && !fi.IsDir() {
+				return f + ext, elems[i:], nil
+			}
+		}
+	}
+	return "", nil, fmt.Errorf("path not found: %s", path)
+}
+
+// Decrypted returns the decrypted content of file f.
+func (b *Backend) decrypted(f string) (map[string]interface{}, error) {
+	buf, err := ioutil.ReadFile(f)
+	if err != nil {
+		return nil, vault.UnavailableFile(err)
+	}
+	h := sha256.Sum256(buf)
+
+	b.mu.Lock()
+	e, ok := b.cache[f]
+	b.mu.Unlock()
+	if ok && e.hash == h {
+		return e.tree, nil
+	}
+
+	tree, err := decrypt(buf, b.identities)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	b.cache[f] = &entry{hash: h, tree: tree}
+	b.mu.Unlock()
+
+	return tree, nil
+}
+
+// Decrypt returns the decrypted tree of a SOPS document.
+// The document is decoded with the order of mapping keys preserved because the MAC is computed in document order.
+func decrypt(buf []byte, identities []age.Identity) (map[string]interface{}, error) {
+	var doc yaml.MapSlice
+	err := yaml.Unmarshal(buf, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var md map[string]interface{}
+	tree := make(yaml.MapSlice, 0, len(doc))
+	for _, it := range doc {
+		if fmt.Sprint(it.Key) == "sops" {
+			md, _ = plain(it.Value).(map[string]interface{})
+			continue
+		}
+		tree = append(tree, it)
+	}
+	if md == nil {
+		return nil, fmt.Errorf("not a sops file")
+	}
+
+	key, err := dataKey(md, identities)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := newDecrypter(md, key)
+	if err != nil {
+		return nil, err
+	}
+	v, err := d.value(tree, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = d.verify(md)
+	if err != nil {
+		return nil, err
+	}
+	return plain(v).(map[string]interface{}), nil
+}
+
+// DataKey returns the data key from the age recipients in SOPS metadata.
+func dataKey(md map[string]interface{}, identities []age.Identity) ([]byte, error) {
+	recipients, _ := md["age"].([]interface{})
+	for _, r := range recipients {
+		m, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		enc, _ := m["enc"].(string)
+		rd, err := age.Decrypt(armor.NewReader(strings.NewReader(enc)), identities...)
+		if err != nil {
+			// try next recipient
+			continue
+		}
+		return ioutil.ReadAll(rd)
+	}
+	return nil, fmt.Errorf("no age recipient matches the identities")
+}
+
+// Decrypter decrypts the values of a SOPS document and computes its MAC.
+type decrypter struct {
+	key []byte
+	// rules from the SOPS metadata that select the values that are encrypted.
+	unencryptedSuffix, encryptedSuffix string
+	unencryptedRegex, encryptedRegex   *regexp.Regexp
+	// macOnlyEncrypted is true when only encrypted values are part of the MAC.
+	macOnlyEncrypted bool
+	// mac is the hash of the plaintext values.
+	mac hash.Hash
+}
+
+// NewDecrypter returns a decrypter for a document with SOPS metadata md.
+func newDecrypter(md map[string]interface{}, key []byte) (*decrypter, error) {
+	d := &decrypter{
+		key: key,
+		mac: sha512.New(),
+	}
+	d.unencryptedSuffix, _ = md["unencrypted_suffix"].(string)
+	d.encryptedSuffix, _ = md["encrypted_suffix"].(string)
+	d.macOnlyEncrypted, _ = md["mac_only_encrypted"].(bool)
+	for k, re := range map[string]**regexp.Regexp{
+		"unencrypted_regex": &d.unencryptedRegex,
+		"encrypted_regex":   &d.encryptedRegex,
+	} {
+		if s, _ := md[k].(string); s != "" {
+			var err error
+			*re, err = regexp.Compile(s)
+			if err != nil {
+				return nil, fmt.Errorf("sops %s: %w", k, err)
+			}
+		}
+	}
+	if d.unencryptedSuffix == "" && d.encryptedSuffix == "" && d.unencryptedRegex == nil && d.encryptedRegex == nil {
+		d.unencryptedSuffix = defaultUnencryptedSuffix
+	}
+	return d, nil
+}
+
+// DefaultUnencryptedSuffix is the suffix of keys with unencrypted values when the SOPS metadata has no rules.
+const defaultUnencryptedSuffix = "_unencrypted"
+
+// Value decrypts in (and its children), the plaintext of leaf values is added to the MAC.
+// Path is the list of mapping keys that lead to in.
+func (d *decrypter) value(in interface{}, path []string) (interface{}, error) {
+	switch v := in.(type) {
+	case yaml.MapSlice:
+		r := make(yaml.MapSlice, len(v))
+		for i, it := range v {
+			k := fmt.Sprint(it.Key)
+			p := append(path[:len(path):len(path)], k)
+			c, err := d.value(it.Value, p)
+			if err != nil {
+				return nil, err
+			}
+			r[i] = yaml.MapItem{Key: k, Value: c}
+		}
+		return r, nil
+	case []interface{}:
+		// list items use the path of the list.
+		r := make([]interface{}, len(v))
+		for i, c := range v {
+			x, err := d.value(c, path)
+			if err != nil {
+				return nil, err
+			}
+			r[i] = x
+		}
+		return r, nil
+	default:
+		if !d.encrypted(path) {
+			if !d.macOnlyEncrypted {
+				d.mac.Write(toBytes(v))
+			}
+			return v, nil
+		}
+		s, ok := v.(string)
+		if !ok || !strings.HasPrefix(s, "ENC[") {
+			return nil, fmt.Errorf("value of %s is not encrypted", strings.Join(path, "."))
+		}
+		pt, err := decryptString(s, strings.Join(path, ":")+":", d.key)
+		if err != nil {
+			return nil, err
+		}
+		d.mac.Write([]byte(pt))
+		return pt, nil
+	}
+}
+
+// Encrypted returns true when SOPS encrypts the value at path.
+func (d *decrypter) encrypted(path []string) bool {
+	encrypted := true
+	if d.unencryptedSuffix != "" {
+		for _, k := range path {
+			if strings.HasSuffix(k, d.unencryptedSuffix) {
+				encrypted = false
+				break
+			}
+		}
+	}
+	if d.encryptedSuffix != "" {
+		encrypted = false
+		for _, k := range path {
+			if strings.HasSuffix(k, d.encryptedSuffix) {
+				encrypted = true
+				break
+			}
+		}
+	}
+	if d.unencryptedRegex != nil {
+		for _, k := range path {
+			if d.unencryptedRegex.MatchString(k) {
+				encrypted = false
+				break
+			}
+		}
+	}
+	if d.encryptedRegex != nil {
+		encrypted = false
+		for _, k := range path {
+			if d.encryptedRegex.MatchString(k) {
+				encrypted = true
+				break
+			}
+		}
+	}
+	return encrypted
+}
+
+// Verify returns an error when the MAC in the SOPS metadata md doesn't match the MAC of the decrypted values.
+// The MAC is encrypted with the lastmodified timestamp as additional data.
+func (d *decrypter) verify(md map[string]interface{}) error {
+	enc, _ := md["mac"].(string)
+	if enc == "" {
+		return fmt.Errorf("sops mac is missing")
+	}
+	lastModified, _ := md["lastmodified"].(string)
+	want, err := decryptString(enc, lastModified, d.key)
+	if err != nil {
+		return fmt.Errorf("sops mac: %w", err)
+	}
+	got := fmt.Sprintf("%X", d.mac.Sum(nil))
+	if !strings.EqualFold(want, got) {
+		return fmt.Errorf("sops mac mismatch, the file has been modified")
+	}
+	return nil
+}
+
+// ToBytes returns the bytes of an unencrypted value the way SOPS adds them to the MAC.
+func toBytes(v interface{}) []byte {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return []byte(v)
+	case bool:
+		if v {
+			return []byte("True")
+		}
+		return []byte("False")
+	case float64:
+		return []byte(strconv.FormatFloat(v, 'f', -1, 64))
+	default:
+		return []byte(fmt.Sprint(v))
+	}
+}
+
+// Plain converts the ordered mappings in v to maps.
+func plain(v interface{}) interface{} {
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		m := make(map[string]interface{}, len(v))
+		for _, it := range v {
+			m[fmt.Sprint(it.Key)] = plain(it.Value)
+		}
+		return m
+	case []interface{}:
+		r := make([]interface{}, len(v))
+		for i, c := range v {
+			r[i] = plain(c)
+		}
+		return r
+	default:
+		return v
+	}
+}
+
+var encRE = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.+),tag:(.+),type:(.+)\]$`)
+
+// DecryptString decrypts a SOPS value like ENC[AES256_GCM,data:...,iv:...,tag:...,type:str].
+func decryptString(s, additionalData string, key []byte) (string, error) {
+	m := encRE.FindStringSubmatch(s)
+	if m == nil {
+		return "", fmt.Errorf("malformed encrypted value")
+	}
+	data, err := base64.StdEncoding.DecodeString(m[1])
+	if err != nil {
+		return "", fmt.Errorf("data: %w", err)
+	}
+	iv, err := base64.StdEncoding.DecodeString(m[2])
+	if err != nil {
+		return "", fmt.Errorf("iv: %w", err)
+	}
+	tag, err := base64.StdEncoding.DecodeString(m[3])
+	if err != nil {
+		return "", fmt.Errorf("tag: %w", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return "", err
+	}
+	pt, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		return "", fmt.Errorf("decrypt %s: %w", strings.TrimSuffix(additionalData, ":"), err)
+	}
+	return string(pt), nil
+}
+
+var _ vault.Loginer = &Backend{}
@@ -0,0 +1,219 @@
+package sops
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+func TestGet(t *testing.T) {
+	id, err := age.GenerateX25519Identity()
+	assert.NoError(t, err)
+	key := make([]byte, 32)
+	_, err = rand.Read(key)
+	assert.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "vaultsecret")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "app.yaml"), []byte(fmt.Sprintf(`
+user: %s
+db:
+  password: %s
+  port: %s
+  hosts:
+  - %s
+  comment_unencrypted: plain
+%s`,
+		testEncrypt(t, "superman", "user:", key),
+		testEncrypt(t, "supersecret", "db:password:", key),
+		testEncrypt(t, "5432", "db:port:", key),
+		testEncrypt(t, "db.example.com", "db:hosts:", key),
+		testMetadata(t, key, id, "", "superman", "supersecret", "5432", "db.example.com", "plain"),
+	)), 0600)
+	assert.NoError(t, err)
+
+	b, err := New(dir, []age.Identity{id})
+	assert.NoError(t, err)
+
+	t.Run("should_get_top_level_values", func(t *testing.T) {
+		got, err := b.Get("app")
+		assert.NoError(t, err)
+		assert.Equal(t, "superman", got["user"])
+		assert.JSONEq(t, `{"password":"supersecret","port":"5432","hosts":["db.example.com"],"comment_unencrypted":"plain"}`, got["db"])
+	})
+
+	t.Run("should_get_nested_values", func(t *testing.T) {
+		got, err := b.Get("app/db")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{
+			"password":            "supersecret",
+			"port":                "5432",
+			"hosts":               `["db.example.com"]`,
+			"comment_unencrypted": "plain",
+		}, got)
+	})
+
+	t.Run("should_error_on_missing_key", func(t *testing.T) {
+		_, err := b.Get("app/missing")
+		assert.Error(t, err)
+		_, err = b.Get("app/user")
+		assert.Error(t, err)
+	})
+
+	t.Run("should_error_on_wrong_identity", func(t *testing.T) {
+		other, err := age.GenerateX25519Identity()
+		assert.NoError(t, err)
+		b, err := New(dir, []age.Identity{other})
+		assert.NoError(t, err)
+		_, err = b.Get("app")
+		assert.Error(t, err)
+	})
+
+	t.Run("should_error_on_value_moved_to_other_key", func(t *testing.T) {
+		err := ioutil.WriteFile(filepath.Join(dir, "moved.yaml"), []byte(fmt.Sprintf(`
+user: %s
+%s`,
+			testEncrypt(t, "supersecret", "db:password:", key),
+			testMetadata(t, key, id, "", "supersecret"),
+		)), 0600)
+		assert.NoError(t, err)
+		_, err = b.Get("moved")
+		assert.Error(t, err)
+	})
+
+	t.Run("should_error_on_unencrypted_value", func(t *testing.T) {
+		err := ioutil.WriteFile(filepath.Join(dir, "plain.yaml"), []byte(fmt.Sprintf(`
+user: superman
+%s`,
+			testMetadata(t, key, id, "", "superman"),
+		)), 0600)
+		assert.NoError(t, err)
+		_, err = b.Get("plain")
+		assert.Error(t, err)
+	})
+
+	t.Run("should_accept_unencrypted_value_allowed_by_encrypted_regex", func(t *testing.T) {
+		err := ioutil.WriteFile(filepath.Join(dir, "regex.yaml"), []byte(fmt.Sprintf(`
+user: superman
+password: %s
+%s`,
+			testEncrypt(t, "supersecret", "password:", key),
+			testMetadata(t, key, id, "  encrypted_regex: ^password$\n", "superman", "supersecret"),
+		)), 0600)
+		assert.NoError(t, err)
+		got, err := b.Get("regex")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"user": "superman", "password": "supersecret"}, got)
+	})
+
+	t.Run("should_error_on_removed_value", func(t *testing.T) {
+		err := ioutil.WriteFile(filepath.Join(dir, "removed.yaml"), []byte(fmt.Sprintf(`
+user: %s
+%s`,
+			testEncrypt(t, "superman", "user:", key),
+			testMetadata(t, key, id, "", "superman", "supersecret"),
+		)), 0600)
+		assert.NoError(t, err)
+		_, err = b.Get("removed")
+		assert.Error(t, err)
+	})
+
+	t.Run("should_error_on_missing_mac", func(t *testing.T) {
+		err := ioutil.WriteFile(filepath.Join(dir, "nomac.yaml"), []byte(fmt.Sprintf(`
+user: %s
+sops:
+  age:
+  - recipient: %s
+    enc: |
+%s`,
+			testEncrypt(t, "superman", "user:", key),
+			id.Recipient().String(),
+			testIndent(testAgeEncrypt(t, key, id.Recipient()), "      "),
+		)), 0600)
+		assert.NoError(t, err)
+		_, err = b.Get("nomac")
+		assert.Error(t, err)
+	})
+}
+
+// TestMetadata returns the sops metadata of a document with the plaintext values in document order.
+// Rules are added to the metadata as is.
+func testMetadata(t *testing.T, key []byte, id *age.X25519Identity, rules string, values ...string) string {
+	t.Helper()
+
+	h := sha512.New()
+	for _, v := range values {
+		h.Write([]byte(v))
+	}
+	lastModified := "2021-09-01T10:00:00Z"
+	return fmt.Sprintf(`sops:
+  age:
+  - recipient: %s
+    enc: |
+%s  lastmodified: "%s"
+  mac: %s
+%s  version: 3.7.1
+`,
+		id.Recipient().String(),
+		testIndent(testAgeEncrypt(t, key, id.Recipient()), "      "),
+		lastModified,
+		testEncrypt(t, fmt.Sprintf("%X", h.Sum(nil)), lastModified, key),
+		rules,
+	)
+}
+
+// TestEncrypt encrypts a value the way SOPS does.
+func testEncrypt(t *testing.T, value, additionalData string, key []byte) string {
+	t.Helper()
+
+	iv := make([]byte, 32)
+	_, err := rand.Read(iv)
+	assert.NoError(t, err)
+	block, err := aes.NewCipher(key)
+	assert.NoError(t, err)
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	assert.NoError(t, err)
+	out := gcm.Seal(nil, iv, []byte(value), []byte(additionalData))
+	n := len(out) - gcm.Overhead()
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:str]",
+		base64.StdEncoding.EncodeToString(out[:n]),
+		base64.StdEncoding.EncodeToString(iv),
+		base64.StdEncoding.EncodeToString(out[n:]))
+}
+
+// TestAgeEncrypt returns the armored age encryption of key.
+func testAgeEncrypt(t *testing.T, key []byte, r age.Recipient) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	aw := armor.NewWriter(&buf)
+	w, err := age.Encrypt(aw, r)
+	assert.NoError(t, err)
+	_, err = w.Write(key)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	assert.NoError(t, aw.Close())
+	return buf.String()
+}
+
+func testIndent(s, indent string) string {
+	var r string
+	for _, l := range bytes.Split(bytes.TrimRight([]byte(s), "\n"), []byte("\n")) {
+		r += indent + string(l) + "\n"
+	}
+	return r
+}
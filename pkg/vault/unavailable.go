@@ -0,0 +1,48 @@
+package vault
+
+import (
+	"errors"
+	"net/http"
+	"os"
+)
+
+// Unavailable returns err wrapping ErrUnavailable, nil when err is nil.
+// Unlike fmt.Errorf with %v, errors.As still finds the errors in the chain of err.
+func Unavailable(err error) error {
+	if err == nil || errors.Is(err, ErrUnavailable) {
+		return err
+	}
+	return &unavailableError{err: err}
+}
+
+// UnavailableError is an error of a backend that can't serve requests.
+type unavailableError struct {
+	err error
+}
+
+func (e *unavailableError) Error() string {
+	return ErrUnavailable.Error() + ": " + e.err.Error()
+}
+
+func (e *unavailableError) Unwrap() error {
+	return e.err
+}
+
+func (e *unavailableError) Is(target error) bool {
+	return target == ErrUnavailable
+}
+
+// UnavailableStatus returns true when the HTTP status code of a response shows the backend can't serve requests
+// (5xx or 429 Too Many Requests).
+func UnavailableStatus(code int) bool {
+	return code >= http.StatusInternalServerError || code == http.StatusTooManyRequests
+}
+
+// UnavailableFile returns err wrapping ErrUnavailable when err is an error reading a file other than not found or
+// permission denied, for example an I/O error of a volume that's no longer mounted.
+func UnavailableFile(err error) error {
+	if err == nil || os.IsNotExist(err) || os.IsPermission(err) {
+		return err
+	}
+	return Unavailable(err)
+}
@@ -0,0 +1,34 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// ParseJSONValue returns the fields of a JSON object or a "value" field with b when b isn't a JSON object.
+// Backends that store a secret as a single string (like AWS Secrets Manager or GCP Secret Manager) use it to turn
+// the secret into fields.
+func ParseJSONValue(b []byte) map[string]string {
+	r, err := ParseJSON(b)
+	if err != nil {
+		return map[string]string{"value": string(b)}
+	}
+	return r
+}
+
+// ParseJSON returns the fields of a JSON object, numbers are kept as is.
+func ParseJSON(b []byte) (map[string]string, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	data := map[string]interface{}{}
+	if err := d.Decode(&data); err != nil {
+		return nil, err
+	}
+
+	r := make(map[string]string, len(data))
+	for k, v := range data {
+		r[k] = fmt.Sprint(v)
+	}
+	return r, nil
+}
@@ -1,11 +1,79 @@
 package vault
 
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrUnavailable is wrapped by errors that are caused by a backend that can't serve requests, for example because of
+// a connection error or a 5xx response.
+// Other errors (like permission denied or path not found) show the backend is available.
+var ErrUnavailable = errors.New("backend unavailable")
+
+// Loginer authenticates with a secret backend like HashiCorp Vault.
 type Loginer interface {
-	// Login vault
-	Login(authPath, role string) (Getter, error)
+	// Login returns a Getter that accesses the backend on behalf of the requester described by req.
+	Login(req LoginRequest) (Getter, error)
+}
+
+// LoginRequest describes the requester that accesses a backend.
+// Backends use the fields they need and ignore the others.
+type LoginRequest struct {
+	// AuthPath is the mount path of the auth method, for example "kubernetes".
+	AuthPath string
+	// Role to login with.
+	Role string
+	// Namespace of the Secret that is being populated.
+	Namespace string
+	// JWT is a ServiceAccount token to login with.
+	// When empty the backend uses the identity of vault-secret itself.
+	JWT string
 }
 
 type Getter interface {
 	// Get values from vault.
 	Get(path string) (map[string]string, error)
 }
+
+// Putter is implemented by Getters that can write to vault.
+type Putter interface {
+	// Put merges values into the secret at path.
+	// Keys that are not in values are kept, the write is skipped when nothing changes.
+	Put(path string, values map[string]string) error
+}
+
+// Decrypter is implemented by Getters that can decrypt values.
+type Decrypter interface {
+	// Decrypt returns the plaintext of ciphertexts (by field name) decrypted with key.
+	Decrypt(key string, ciphertexts map[string]string) (map[string]string, error)
+}
+
+// DecryptPath returns the Vault path that decrypts with transit key.
+// Key is the name of the transit key, optionally prefixed by the mount path when not mounted at "transit",
+// for example "transit-eu/app" results in "transit-eu/decrypt/app".
+func DecryptPath(key string) string {
+	mount := "transit"
+	if i := strings.LastIndex(key, "/"); i >= 0 {
+		mount, key = key[:i], key[i+1:]
+	}
+	return mount + "/decrypt/" + key
+}
+
+// Wrapper is implemented by Getters that can return a response-wrapped secret.
+type Wrapper interface {
+	// Wrap returns a single use token that unwraps to the secret at path, the token expires after ttl.
+	Wrap(path string, ttl time.Duration) (string, error)
+}
+
+// HealthChecker is implemented by backends that can report whether they are able to serve requests.
+type HealthChecker interface {
+	// Health returns an error when the backend can't serve requests.
+	Health() error
+}
+
+// Invalidator is implemented by backends that cache values.
+type Invalidator interface {
+	// Invalidate removes the cached values of path so the next Get reads the backend.
+	Invalidate(path string)
+}
@@ -0,0 +1,432 @@
+// Package webhookcert manages a self-signed CA and the webhook serving certificate so the webhook can run without
+// cert-manager.
+package webhookcert
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"github.com/go-logr/logr"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;create;update
+// +kubebuilder:rbac:groups=admissionregistration.k8s.io,resources=mutatingwebhookconfigurations,verbs=get;update
+
+const (
+	// CACertKey is the Secret key of the PEM encoded CA certificates.
+	// It contains the current CA followed by the previous CA until the previous CA expires.
+	CACertKey = "ca.crt"
+	// CAKeyKey is the Secret key of the PEM encoded CA private key.
+	CAKeyKey = "ca.key"
+
+	// DefaultValidity is the validity of the serving certificate when Manager.Validity is not set.
+	DefaultValidity = 365 * 24 * time.Hour
+	// DefaultRotateBefore is the time before expiry a certificate is replaced when Manager.RotateBefore is not set.
+	DefaultRotateBefore = 30 * 24 * time.Hour
+	// DefaultInterval is the time between checks when Manager.Interval is not set.
+	DefaultInterval = time.Hour
+
+	// CAValidityFactor is the validity of the CA in serving certificate validities.
+	caValidityFactor = 10
+)
+
+// Manager makes sure the webhook has a valid serving certificate.
+// The CA and serving certificate are stored in a Secret that is shared by all replicas.
+// The serving certificate is written to CertDir and the CA to the caBundle of the MutatingWebhookConfiguration.
+// Certificates are replaced RotateBefore expiry, after a CA is replaced the previous CA stays in the caBundle until it
+// expires so serving certificates of replicas that haven't picked up the change are still trusted.
+// Manager implements manager.Runnable.
+type Manager struct {
+	// Client is used to read and write the Secret and MutatingWebhookConfiguration.
+	// It should not be a cached client as the objects are read before the manager is started.
+	Client client.Client
+	// Namespace of the Secret and the webhook Service.
+	Namespace string
+	// SecretName is the name of the Secret that stores the CA and serving certificate.
+	SecretName string
+	// ServiceName is the name of the webhook Service, it's used for the DNS names of the serving certificate.
+	ServiceName string
+	// WebhookConfiguration is the name of the MutatingWebhookConfiguration that gets the CA bundle, empty to skip.
+	WebhookConfiguration string
+	// CertDir is the directory to write tls.crt and tls.key to.
+	CertDir string
+	// Validity of the serving certificate, defaults to DefaultValidity.
+	Validity time.Duration
+	// RotateBefore is the time before expiry a certificate is replaced, defaults to DefaultRotateBefore.
+	RotateBefore time.Duration
+	// Interval between checks, defaults to DefaultInterval.
+	Interval time.Duration
+
+	Log logr.Logger
+
+	// now is replaced in tests.
+	now func() time.Time
+}
+
+// Start checks the certificates every Interval until stop is closed.
+func (m *Manager) Start(stop <-chan struct{}) error {
+	interval := m.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-t.C:
+			err := m.Ensure(context.Background())
+			if err != nil {
+				m.Log.Error(err, "ensure webhook certificate")
+			}
+		}
+	}
+}
+
+// NeedLeaderElection returns false because all replicas serve the webhook.
+func (m *Manager) NeedLeaderElection() bool {
+	return false
+}
+
+// Ensure creates or rotates the certificates in the Secret when needed, sets the caBundle of the
+// MutatingWebhookConfiguration and writes the serving certificate to CertDir.
+func (m *Manager) Ensure(ctx context.Context) error {
+	secret, err := m.ensureSecret(ctx)
+	if err != nil {
+		return fmt.Errorf("webhook certificate secret %s/%s: %w", m.Namespace, m.SecretName, err)
+	}
+
+	// trust a new CA before serving a certificate signed by it.
+	err = m.ensureCABundle(ctx, secret.Data[CACertKey])
+	if err != nil {
+		return fmt.Errorf("webhook configuration %s: %w", m.WebhookConfiguration, err)
+	}
+
+	err = m.writeFiles(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return fmt.Errorf("webhook certificate files: %w", err)
+	}
+	return nil
+}
+
+// EnsureSecret returns the Secret with valid certificates, it creates or updates the Secret when needed.
+// Conflicting writes by other replicas are resolved by reading the Secret again.
+func (m *Manager) ensureSecret(ctx context.Context) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		err := m.Client.Get(ctx, types.NamespacedName{Namespace: m.Namespace, Name: m.SecretName}, secret)
+		if apierrors.IsNotFound(err) {
+			secret = &corev1.Secret{Type: corev1.SecretTypeTLS}
+			secret.Namespace, secret.Name = m.Namespace, m.SecretName
+			if !m.update(secret) {
+				return fmt.Errorf("generating certificates failed")
+			}
+			err = m.Client.Create(ctx, secret)
+			if apierrors.IsAlreadyExists(err) {
+				// created by another replica.
+				return apierrors.NewConflict(corev1.Resource("secrets"), m.SecretName, err)
+			}
+			if err == nil {
+				m.Log.Info("created webhook certificate", "secret", m.SecretName)
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		if !m.update(secret) {
+			return nil
+		}
+		err = m.Client.Update(ctx, secret)
+		if err == nil {
+			m.Log.Info("rotated webhook certificate", "secret", m.SecretName)
+		}
+		return err
+	})
+	return secret, err
+}
+
+// Update replaces the certificates in secret that are missing, invalid or about to expire.
+// It returns true when secret has changed.
+func (m *Manager) update(secret *corev1.Secret) bool {
+	now := m.clock()
+	validity, rotateBefore := m.validity()
+
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	cas := parseCerts(secret.Data[CACertKey])
+	ca, caKey := (*x509.Certificate)(nil), (*ecdsa.PrivateKey)(nil)
+	if len(cas) > 0 {
+		if _, err := tls.X509KeyPair(encodeCert(cas[0]), secret.Data[CAKeyKey]); err == nil {
+			ca, caKey = cas[0], parseKey(secret.Data[CAKeyKey])
+		}
+	}
+
+	changed, rotatedCA := false, false
+	// a CA must outlive the serving certificates it signs.
+	if ca == nil || caKey == nil || now.Add(validity+rotateBefore).After(ca.NotAfter) {
+		var err error
+		ca, caKey, err = newCA(now, caValidityFactor*validity)
+		if err != nil {
+			m.Log.Error(err, "generate webhook CA")
+			return false
+		}
+		// keep the previous CA in the bundle while it's valid.
+		bundle := encodeCert(ca)
+		if len(cas) > 0 && now.Before(cas[0].NotAfter) {
+			bundle = append(bundle, encodeCert(cas[0])...)
+		}
+		secret.Data[CACertKey] = bundle
+		secret.Data[CAKeyKey] = encodeKey(caKey)
+		changed, rotatedCA = true, true
+	} else if len(cas) > 1 && now.After(cas[1].NotAfter) {
+		// drop the expired previous CA.
+		secret.Data[CACertKey] = encodeCert(ca)
+		changed = true
+	}
+
+	if rotatedCA || !m.servingValid(secret, ca, now.Add(rotateBefore)) {
+		cert, key, err := newServingCert(now, validity, m.dnsNames(), ca, caKey)
+		if err != nil {
+			m.Log.Error(err, "generate webhook serving certificate")
+			return false
+		}
+		secret.Data[corev1.TLSCertKey] = cert
+		secret.Data[corev1.TLSPrivateKeyKey] = key
+		changed = true
+	}
+
+	return changed
+}
+
+// ServingValid returns true when the serving certificate in secret is signed by ca, matches its key, has the DNS
+// names of the Service and is valid at t.
+func (m *Manager) servingValid(secret *corev1.Secret, ca *x509.Certificate, t time.Time) bool {
+	pair, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return false
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return false
+	}
+	if cert.CheckSignatureFrom(ca) != nil || t.After(cert.NotAfter) {
+		return false
+	}
+	for _, n := range m.dnsNames() {
+		if cert.VerifyHostname(n) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteFiles writes the serving certificate and key to CertDir when they have changed.
+// Files are replaced by a rename so the webhook server never reads a partially written file.
+// The key is written first, the webhook server reloads the pair when the certificate changes.
+func (m *Manager) writeFiles(cert, key []byte) error {
+	err := os.MkdirAll(m.CertDir, 0700)
+	if err != nil {
+		return err
+	}
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{{corev1.TLSPrivateKeyKey, key}, {corev1.TLSCertKey, cert}} {
+		p := filepath.Join(m.CertDir, f.name)
+		if b, err := ioutil.ReadFile(p); err == nil && bytes.Equal(b, f.data) {
+			continue
+		}
+		tmp := p + ".tmp"
+		err := ioutil.WriteFile(tmp, f.data, 0600)
+		if err != nil {
+			return err
+		}
+		err = os.Rename(tmp, p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnsureCABundle sets the caBundle of all webhooks in the MutatingWebhookConfiguration to bundle.
+// A missing MutatingWebhookConfiguration is not an error, it's checked again on the next interval.
+func (m *Manager) ensureCABundle(ctx context.Context, bundle []byte) error {
+	if m.WebhookConfiguration == "" {
+		return nil
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		wc := &admissionregistrationv1.MutatingWebhookConfiguration{}
+		err := m.Client.Get(ctx, types.NamespacedName{Name: m.WebhookConfiguration}, wc)
+		if apierrors.IsNotFound(err) {
+			m.Log.Info("webhook configuration not found, caBundle not set", "name", m.WebhookConfiguration)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		changed := false
+		for i := range wc.Webhooks {
+			if !bytes.Equal(wc.Webhooks[i].ClientConfig.CABundle, bundle) {
+				wc.Webhooks[i].ClientConfig.CABundle = bundle
+				changed = true
+			}
+		}
+		if !changed {
+			return nil
+		}
+		err = m.Client.Update(ctx, wc)
+		if err == nil {
+			m.Log.Info("updated webhook caBundle", "name", m.WebhookConfiguration)
+		}
+		return err
+	})
+}
+
+// DNSNames returns the names the webhook Service is reached by.
+func (m *Manager) dnsNames() []string {
+	return []string{
+		m.ServiceName,
+		fmt.Sprintf("%s.%s", m.ServiceName, m.Namespace),
+		fmt.Sprintf("%s.%s.svc", m.ServiceName, m.Namespace),
+		fmt.Sprintf("%s.%s.svc.cluster.local", m.ServiceName, m.Namespace),
+	}
+}
+
+// Validity returns the validity and rotate before durations with defaults applied.
+func (m *Manager) validity() (time.Duration, time.Duration) {
+	validity, rotateBefore := m.Validity, m.RotateBefore
+	if validity <= 0 {
+		validity = DefaultValidity
+	}
+	if rotateBefore <= 0 {
+		rotateBefore = DefaultRotateBefore
+	}
+	return validity, rotateBefore
+}
+
+func (m *Manager) clock() time.Time {
+	if m.now != nil {
+		return m.now()
+	}
+	return time.Now()
+}
+
+// NewCA returns a self-signed CA certificate and its key.
+func newCA(now time.Time, validity time.Duration) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serialNumber(),
+		Subject:               pkix.Name{CommonName: fmt.Sprintf("vault-secret-webhook-ca@%d", now.Unix())},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	return cert, key, err
+}
+
+// NewServingCert returns a PEM encoded serving certificate for dnsNames signed by ca and its PEM encoded key.
+func newServingCert(now time.Time, validity time.Duration, dnsNames []string, ca *x509.Certificate, caKey *ecdsa.PrivateKey) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serialNumber(),
+		Subject:      pkix.Name{CommonName: dnsNames[len(dnsNames)-2]},
+		DNSNames:     dnsNames,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return encodeCert(cert), encodeKey(key), nil
+}
+
+func serialNumber() *big.Int {
+	n, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return big.NewInt(time.Now().UnixNano())
+	}
+	return n
+}
+
+// ParseCerts returns the certificates in PEM encoded b, invalid blocks are skipped.
+func parseCerts(b []byte) []*x509.Certificate {
+	var r []*x509.Certificate
+	for {
+		var blk *pem.Block
+		blk, b = pem.Decode(b)
+		if blk == nil {
+			return r
+		}
+		if c, err := x509.ParseCertificate(blk.Bytes); err == nil {
+			r = append(r, c)
+		}
+	}
+}
+
+// ParseKey returns the ECDSA key in PEM encoded b or nil.
+func parseKey(b []byte) *ecdsa.PrivateKey {
+	blk, _ := pem.Decode(b)
+	if blk == nil {
+		return nil
+	}
+	k, err := x509.ParseECPrivateKey(blk.Bytes)
+	if err != nil {
+		return nil
+	}
+	return k
+}
+
+func encodeCert(c *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})
+}
+
+func encodeKey(k *ecdsa.PrivateKey) []byte {
+	// marshalling a P256 key doesn't fail.
+	b, _ := x509.MarshalECPrivateKey(k)
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b})
+}
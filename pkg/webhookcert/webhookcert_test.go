@@ -0,0 +1,163 @@
+package webhookcert
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// TestManager returns a Manager with a fake client that contains a MutatingWebhookConfiguration, a function that
+// advances the clock and a function that removes the CertDir.
+func testManager(t *testing.T) (*Manager, func(time.Duration), func()) {
+	dir, err := ioutil.TempDir("", "webhookcert")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wc := &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "webhook"},
+		Webhooks:   []admissionregistrationv1.MutatingWebhook{{Name: "a.mmlt.nl"}, {Name: "b.mmlt.nl"}},
+	}
+	now := time.Now()
+	m := &Manager{
+		Client:               fake.NewFakeClient(wc),
+		Namespace:            "system",
+		SecretName:           "webhook-cert",
+		ServiceName:          "webhook-service",
+		WebhookConfiguration: "webhook",
+		CertDir:              dir,
+		Log:                  logf.Log,
+		now:                  func() time.Time { return now },
+	}
+	return m, func(d time.Duration) { now = now.Add(d) }, func() { os.RemoveAll(dir) }
+}
+
+func (m *Manager) testSecret(t *testing.T) *corev1.Secret {
+	s := &corev1.Secret{}
+	err := m.Client.Get(context.Background(), types.NamespacedName{Namespace: m.Namespace, Name: m.SecretName}, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// TestVerify verifies the serving certificate in CertDir against the caBundle of the webhook configuration.
+func (m *Manager) testVerify(t *testing.T) error {
+	wc := &admissionregistrationv1.MutatingWebhookConfiguration{}
+	err := m.Client.Get(context.Background(), types.NamespacedName{Name: m.WebhookConfiguration}, wc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, err := tls.LoadX509KeyPair(filepath.Join(m.CertDir, "tls.crt"), filepath.Join(m.CertDir, "tls.key"))
+	if err != nil {
+		return err
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return err
+	}
+
+	for _, w := range wc.Webhooks {
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(w.ClientConfig.CABundle)
+		_, err = cert.Verify(x509.VerifyOptions{
+			DNSName:     "webhook-service.system.svc",
+			Roots:       pool,
+			CurrentTime: m.now(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestEnsure(t *testing.T) {
+	t.Run("should_create_certificates", func(t *testing.T) {
+		m, _, cleanup := testManager(t)
+		defer cleanup()
+
+		assert.NoError(t, m.Ensure(context.Background()))
+		s := m.testSecret(t)
+		assert.Equal(t, corev1.SecretTypeTLS, s.Type)
+		assert.NoError(t, m.testVerify(t))
+	})
+
+	t.Run("should_keep_valid_certificates", func(t *testing.T) {
+		m, advance, cleanup := testManager(t)
+		defer cleanup()
+
+		assert.NoError(t, m.Ensure(context.Background()))
+		before := m.testSecret(t)
+		advance(DefaultValidity - DefaultRotateBefore - time.Hour)
+		assert.NoError(t, m.Ensure(context.Background()))
+		assert.Equal(t, before.Data, m.testSecret(t).Data)
+	})
+
+	t.Run("should_rotate_serving_certificate_before_expiry", func(t *testing.T) {
+		m, advance, cleanup := testManager(t)
+		defer cleanup()
+
+		assert.NoError(t, m.Ensure(context.Background()))
+		before := m.testSecret(t)
+		advance(DefaultValidity - DefaultRotateBefore + time.Hour)
+		assert.NoError(t, m.Ensure(context.Background()))
+		after := m.testSecret(t)
+		assert.Equal(t, before.Data[CAKeyKey], after.Data[CAKeyKey])
+		assert.NotEqual(t, before.Data[corev1.TLSCertKey], after.Data[corev1.TLSCertKey])
+		assert.NoError(t, m.testVerify(t))
+	})
+
+	t.Run("should_rotate_ca_and_keep_previous_ca_in_bundle", func(t *testing.T) {
+		m, advance, cleanup := testManager(t)
+		defer cleanup()
+
+		assert.NoError(t, m.Ensure(context.Background()))
+		before := m.testSecret(t)
+		advance(caValidityFactor*DefaultValidity - DefaultValidity - DefaultRotateBefore + time.Hour)
+		assert.NoError(t, m.Ensure(context.Background()))
+		after := m.testSecret(t)
+		assert.NotEqual(t, before.Data[CAKeyKey], after.Data[CAKeyKey])
+		assert.Len(t, parseCerts(after.Data[CACertKey]), 2)
+		assert.NoError(t, m.testVerify(t))
+
+		// previous CA expires.
+		advance(DefaultValidity + DefaultRotateBefore)
+		assert.NoError(t, m.Ensure(context.Background()))
+		assert.Len(t, parseCerts(m.testSecret(t).Data[CACertKey]), 1)
+	})
+
+	t.Run("should_replace_certificate_for_other_service", func(t *testing.T) {
+		m, _, cleanup := testManager(t)
+		defer cleanup()
+
+		assert.NoError(t, m.Ensure(context.Background()))
+		before := m.testSecret(t)
+		m.ServiceName = "other"
+		assert.NoError(t, m.Ensure(context.Background()))
+		assert.NotEqual(t, before.Data[corev1.TLSCertKey], m.testSecret(t).Data[corev1.TLSCertKey])
+	})
+
+	t.Run("should_skip_missing_webhook_configuration", func(t *testing.T) {
+		m, _, cleanup := testManager(t)
+		defer cleanup()
+		m.WebhookConfiguration = "missing"
+
+		assert.NoError(t, m.Ensure(context.Background()))
+		_, err := os.Stat(filepath.Join(m.CertDir, "tls.crt"))
+		assert.NoError(t, err)
+	})
+}
@@ -0,0 +1,193 @@
+// Package webhookconfig registers the MutatingWebhookConfiguration that sends Secrets to the webhook.
+package webhookconfig
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	"github.com/mmlt/vault-secret/pkg/mutator"
+	"reflect"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// +kubebuilder:rbac:groups=admissionregistration.k8s.io,resources=mutatingwebhookconfigurations,verbs=get;create;update
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+
+const (
+	// WebhookName is the name of the webhook in the MutatingWebhookConfiguration.
+	WebhookName = "vaultsecret.mmlt.nl"
+	// NamespaceNameLabel is the label the API server (1.21+) sets on each Namespace with the name of the Namespace.
+	// On older clusters the installer must set it on the Namespaces that are excluded.
+	NamespaceNameLabel = "kubernetes.io/metadata.name"
+	// DefaultTimeout is the timeout of a webhook call when Registration.Timeout is not set.
+	DefaultTimeout = 10 * time.Second
+)
+
+// Registration creates or updates a MutatingWebhookConfiguration with a single webhook that sends the create and
+// update requests of selected Secrets to the webhook Service.
+type Registration struct {
+	// Client is used to read and write the MutatingWebhookConfiguration.
+	Client client.Client
+	// Name of the MutatingWebhookConfiguration.
+	Name string
+	// Namespace and ServiceName of the webhook Service.
+	Namespace   string
+	ServiceName string
+	// Path of the webhook handler.
+	Path string
+	// ObjectSelector selects the Secrets that are sent to the webhook, nil selects Secrets with the
+	// vault.mmlt.nl/inject=true label.
+	ObjectSelector *metav1.LabelSelector
+	// ExcludeNamespaces are the namespaces whose Secrets are never sent to the webhook.
+	// Namespace is always excluded so vault-secret doesn't depend on itself to start.
+	ExcludeNamespaces []string
+	// Timeout of a webhook call, defaults to DefaultTimeout.
+	Timeout time.Duration
+	// ReinvocationPolicy defaults to Never.
+	ReinvocationPolicy admissionregistrationv1.ReinvocationPolicyType
+	// FailurePolicy defaults to Fail.
+	FailurePolicy admissionregistrationv1.FailurePolicyType
+
+	Log logr.Logger
+}
+
+// Register creates the MutatingWebhookConfiguration or updates it when it differs from the desired configuration.
+// The caBundle of an existing webhook is kept, it's set by cert-manager or the webhookcert package.
+// An error is returned when Namespace doesn't have the NamespaceNameLabel, without it Namespace can't be excluded.
+func (r *Registration) Register(ctx context.Context) error {
+	err := r.checkNamespaceLabel(ctx)
+	if err != nil {
+		return err
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		want := r.webhook()
+
+		wc := &admissionregistrationv1.MutatingWebhookConfiguration{}
+		err := r.Client.Get(ctx, types.NamespacedName{Name: r.Name}, wc)
+		if apierrors.IsNotFound(err) {
+			wc.Name = r.Name
+			wc.Webhooks = []admissionregistrationv1.MutatingWebhook{want}
+			err = r.Client.Create(ctx, wc)
+			if apierrors.IsAlreadyExists(err) {
+				// created by another replica.
+				return apierrors.NewConflict(admissionregistrationv1.Resource("mutatingwebhookconfigurations"), r.Name, err)
+			}
+			if err == nil {
+				r.Log.Info("created webhook configuration", "name", r.Name)
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, w := range wc.Webhooks {
+			if w.Name == want.Name {
+				want.ClientConfig.CABundle = w.ClientConfig.CABundle
+			}
+		}
+		if len(wc.Webhooks) == 1 && reflect.DeepEqual(wc.Webhooks[0], want) {
+			return nil
+		}
+		wc.Webhooks = []admissionregistrationv1.MutatingWebhook{want}
+		err = r.Client.Update(ctx, wc)
+		if err == nil {
+			r.Log.Info("updated webhook configuration", "name", r.Name)
+		}
+		return err
+	})
+}
+
+// CheckNamespaceLabel returns an error when Namespace doesn't have a NamespaceNameLabel with its name.
+func (r *Registration) checkNamespaceLabel(ctx context.Context) error {
+	ns := &corev1.Namespace{}
+	err := r.Client.Get(ctx, types.NamespacedName{Name: r.Namespace}, ns)
+	if err != nil {
+		return fmt.Errorf("namespace %s: %w", r.Namespace, err)
+	}
+	if ns.Labels[NamespaceNameLabel] != r.Namespace {
+		return fmt.Errorf("namespace %s must have label %s=%s to be excluded from the webhook (set by Kubernetes 1.21+)",
+			r.Namespace, NamespaceNameLabel, r.Namespace)
+	}
+	return nil
+}
+
+// Webhook returns the desired webhook.
+// All defaults are set so it can be compared with the webhook read from the API server.
+func (r *Registration) webhook() admissionregistrationv1.MutatingWebhook {
+	path := r.Path
+	port := int32(443)
+	scope := admissionregistrationv1.NamespacedScope
+	// dry run requests are allowed without reading Vault or requesting tokens.
+	sideEffects := admissionregistrationv1.SideEffectClassNoneOnDryRun
+	timeout := int32(DefaultTimeout / time.Second)
+	if r.Timeout > 0 {
+		timeout = int32(r.Timeout / time.Second)
+	}
+	reinvocation := admissionregistrationv1.NeverReinvocationPolicy
+	if r.ReinvocationPolicy != "" {
+		reinvocation = r.ReinvocationPolicy
+	}
+	failure := admissionregistrationv1.Fail
+	if r.FailurePolicy != "" {
+		failure = r.FailurePolicy
+	}
+	match := admissionregistrationv1.Equivalent
+
+	objectSelector := r.ObjectSelector
+	if objectSelector == nil {
+		objectSelector = &metav1.LabelSelector{MatchLabels: map[string]string{mutator.InjectLabel: "true"}}
+	}
+
+	excluded := []string{r.Namespace}
+	for _, ns := range r.ExcludeNamespaces {
+		if ns != r.Namespace {
+			excluded = append(excluded, ns)
+		}
+	}
+
+	return admissionregistrationv1.MutatingWebhook{
+		Name: WebhookName,
+		ClientConfig: admissionregistrationv1.WebhookClientConfig{
+			Service: &admissionregistrationv1.ServiceReference{
+				Namespace: r.Namespace,
+				Name:      r.ServiceName,
+				Path:      &path,
+				Port:      &port,
+			},
+		},
+		Rules: []admissionregistrationv1.RuleWithOperations{{
+			Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create, admissionregistrationv1.Update},
+			Rule: admissionregistrationv1.Rule{
+				APIGroups:   []string{""},
+				APIVersions: []string{"v1"},
+				Resources:   []string{"secrets"},
+				Scope:       &scope,
+			},
+		}},
+		FailurePolicy: &failure,
+		MatchPolicy:   &match,
+		NamespaceSelector: &metav1.LabelSelector{
+			MatchExpressions: []metav1.LabelSelectorRequirement{{
+				Key:      NamespaceNameLabel,
+				Operator: metav1.LabelSelectorOpNotIn,
+				Values:   excluded,
+			}},
+		},
+		ObjectSelector: objectSelector,
+		SideEffects:    &sideEffects,
+		TimeoutSeconds: &timeout,
+		// the webhook server decodes v1beta1 AdmissionReviews.
+		AdmissionReviewVersions: []string{"v1beta1"},
+		ReinvocationPolicy:      &reinvocation,
+	}
+}
@@ -0,0 +1,110 @@
+package webhookconfig
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func testRegistration(c client.Client) *Registration {
+	return &Registration{
+		Client:            c,
+		Name:              "webhook",
+		Namespace:         "vault-secret",
+		ServiceName:       "webhook-service",
+		Path:              "/mutate-v1-secret",
+		ExcludeNamespaces: []string{"kube-system", "vault-secret"},
+		Log:               logf.Log,
+	}
+}
+
+// TestNamespace is the Namespace vault-secret runs in.
+var testNamespace = &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+	Name:   "vault-secret",
+	Labels: map[string]string{NamespaceNameLabel: "vault-secret"},
+}}
+
+func testGet(t *testing.T, c client.Client) *admissionregistrationv1.MutatingWebhookConfiguration {
+	wc := &admissionregistrationv1.MutatingWebhookConfiguration{}
+	err := c.Get(context.Background(), types.NamespacedName{Name: "webhook"}, wc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wc
+}
+
+func TestRegister(t *testing.T) {
+	t.Run("should_create_webhook_configuration", func(t *testing.T) {
+		c := fake.NewFakeClient(testNamespace)
+		r := testRegistration(c)
+		r.Timeout = 5 * time.Second
+		r.ReinvocationPolicy = admissionregistrationv1.IfNeededReinvocationPolicy
+
+		assert.NoError(t, r.Register(context.Background()))
+		wc := testGet(t, c)
+		if !assert.Len(t, wc.Webhooks, 1) {
+			return
+		}
+		w := wc.Webhooks[0]
+		assert.Equal(t, "webhook-service", w.ClientConfig.Service.Name)
+		assert.Equal(t, "/mutate-v1-secret", *w.ClientConfig.Service.Path)
+		assert.Equal(t, map[string]string{"vault.mmlt.nl/inject": "true"}, w.ObjectSelector.MatchLabels)
+		assert.Equal(t, []string{"vault-secret", "kube-system"}, w.NamespaceSelector.MatchExpressions[0].Values)
+		assert.EqualValues(t, 5, *w.TimeoutSeconds)
+		assert.Equal(t, admissionregistrationv1.IfNeededReinvocationPolicy, *w.ReinvocationPolicy)
+		assert.Equal(t, admissionregistrationv1.Fail, *w.FailurePolicy)
+		assert.Equal(t, admissionregistrationv1.SideEffectClassNoneOnDryRun, *w.SideEffects)
+	})
+
+	t.Run("should_update_webhook_configuration_and_keep_ca_bundle", func(t *testing.T) {
+		c := fake.NewFakeClient(testNamespace, &admissionregistrationv1.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "webhook"},
+			Webhooks: []admissionregistrationv1.MutatingWebhook{{
+				Name:         WebhookName,
+				ClientConfig: admissionregistrationv1.WebhookClientConfig{CABundle: []byte("ca")},
+			}, {
+				Name: "msecret.kb.io",
+			}},
+		})
+		r := testRegistration(c)
+		r.ObjectSelector = &metav1.LabelSelector{}
+
+		assert.NoError(t, r.Register(context.Background()))
+		wc := testGet(t, c)
+		if !assert.Len(t, wc.Webhooks, 1) {
+			return
+		}
+		assert.Equal(t, []byte("ca"), wc.Webhooks[0].ClientConfig.CABundle)
+		assert.Equal(t, &metav1.LabelSelector{}, wc.Webhooks[0].ObjectSelector)
+	})
+
+	t.Run("should_not_update_unchanged_webhook_configuration", func(t *testing.T) {
+		c := fake.NewFakeClient(testNamespace)
+		r := testRegistration(c)
+
+		assert.NoError(t, r.Register(context.Background()))
+		before := testGet(t, c).ResourceVersion
+		assert.NoError(t, r.Register(context.Background()))
+		assert.Equal(t, before, testGet(t, c).ResourceVersion)
+	})
+
+	t.Run("should_error_when_namespace_has_no_name_label", func(t *testing.T) {
+		c := fake.NewFakeClient(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "vault-secret"}})
+		r := testRegistration(c)
+
+		err := r.Register(context.Background())
+		assert.EqualError(t, err, "namespace vault-secret must have label kubernetes.io/metadata.name=vault-secret to be excluded from the webhook (set by Kubernetes 1.21+)")
+		err = c.Get(context.Background(), types.NamespacedName{Name: "webhook"}, &admissionregistrationv1.MutatingWebhookConfiguration{})
+		assert.True(t, apierrors.IsNotFound(err), "should not register, got %v", err)
+	})
+}